@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// loadBalancersFlag is a repeatable flag mapping load balancer pools to the
+// services handling their traffic, in the form pool:PROTOCOL=namespace/name.
+type loadBalancersFlag map[string]map[corev1.Protocol]string
+
+func (f loadBalancersFlag) String() string {
+	var values []string
+	for pool, services := range f {
+		for protocol, service := range services {
+			values = append(values, fmt.Sprintf("%s:%s=%s", pool, protocol, service))
+		}
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+func (f loadBalancersFlag) Set(value string) error {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 || strings.Count(parts[1], "/") != 1 {
+		return fmt.Errorf("expected pool:PROTOCOL=namespace/name, got %q", value)
+	}
+	key := strings.SplitN(parts[0], ":", 2)
+	if len(key) != 2 {
+		return fmt.Errorf("expected pool:PROTOCOL=namespace/name, got %q", value)
+	}
+
+	pool, protocol := key[0], corev1.Protocol(strings.ToUpper(key[1]))
+	if protocol != corev1.ProtocolTCP && protocol != corev1.ProtocolUDP {
+		return fmt.Errorf("unsupported protocol %s", key[1])
+	}
+	if f[pool] == nil {
+		f[pool] = map[corev1.Protocol]string{}
+	}
+	f[pool][protocol] = parts[1]
+	return nil
+}
@@ -3,6 +3,10 @@ package main
 import (
 	"flag"
 	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"strings"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
@@ -10,51 +14,223 @@ import (
 	clientset "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned"
 	informers "github.com/pdeslaur/kube-pat/pkg/client/informers/externalversions"
 	"github.com/pdeslaur/kube-pat/pkg/forwarder"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/dynamic"
 	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/klog/v2"
 )
 
 var (
-	udpService = flag.String("udp-service", "kube-pat/kube-pat-udp", "Name of the service handling incoming UDP traffic")
-	tcpService = flag.String("tcp-service", "kube-pat/kube-pat-tcp", "Name of the service handling incoming TCP traffic")
+	udpService = flag.String("udp-service", "kube-pat/kube-pat-udp", "Name of the service handling incoming UDP traffic of the default load balancer pool")
+	tcpService = flag.String("tcp-service", "kube-pat/kube-pat-tcp", "Name of the service handling incoming TCP traffic of the default load balancer pool")
+	nflogGroup = flag.Uint("nflog-group", 100, "NFLOG group used for logging connections")
+	dnsZone    = flag.String("dns-zone", "", "DNS zone of the external-dns records published for the translations")
+	dnsServer  = flag.String("dns-server-address", "", "Address of the DNS server answering SRV and A/AAAA queries for the DNS zone, disabled when empty")
+	replicas   = flag.String("replicas-service", "kube-pat/kube-pat-tcp", "Service selecting the replicas, as namespace/name. A deleted translation is finalized once every ready replica stopped using it")
+	drift      = flag.Duration("drift-interval", 30*time.Second, "Interval between the comparisons of the installed IPTables rules with the desired rules, disabled when 0")
+	drain      = flag.Duration("drain-grace-period", 20*time.Second, "Time the established flows are forwarded after a shutdown signal or the removal of a translation")
+	leaseName  = flag.String("leader-election-lease", "kube-pat/kube-pat", "Lease electing the replica updating the load balancers and the translations, as namespace/name. Every replica is the leader when empty")
+
+	configPath = flag.String("config", "", "Path to the configuration file of the controller, overriding the flags it sets. The file is reloaded when it changes")
+	dryRun     = flag.Bool("dry-run", false, "Log the IPTables rules and the load balancer changes of each reconciliation without applying them")
+
+	kubeconfig  = flag.String("kubeconfig", "", "Path to a kubeconfig, to run out of the cluster. Out of the cluster, --dry-run defaults to true and --leader-election-lease to empty")
+	master      = flag.String("master", "", "Address of the Kubernetes API server, overriding the kubeconfig")
+	kubeContext = flag.String("context", "", "Context of the kubeconfig to use, the current context when empty")
+
+	metricsAddress = flag.String("metrics-address", ":9090", "Address serving the Prometheus metrics on /metrics, and the health checks on /healthz and /readyz")
+	debugAddress   = flag.String("debug-address", "localhost:6060", "Address serving the forwarding state on /debug/state and pprof on /debug/pprof/, disabled when empty")
+
+	mixedProtocol       = flag.Bool("mixed-protocol", false, "Publish the TCP and UDP ports of a pool on its TCP load balancer service, when supported by the cluster")
+	loadBalancersConfig = flag.String("load-balancers-config", "", "Deprecated: use the loadBalancers and dedicated settings of --config instead, it can't be used with --config. Path to a file describing the load balancer services created by the controller")
+
+	loadBalancers = loadBalancersFlag{}
 )
 
+func init() {
+	flag.Var(loadBalancers, "load-balancer", "Service handling incoming traffic of a load balancer pool, as pool:PROTOCOL=namespace/name. Can be repeated")
+}
+
 func main() {
+	klog.InitFlags(nil)
+	if len(os.Args) > 1 && os.Args[1] == "render" {
+		// render [flags] FILE...
+		flag.CommandLine.Parse(os.Args[2:])
+		err := render(flag.Args(), os.Stdout)
+		if err != nil {
+			klog.ErrorS(err, "Failed to render")
+		}
+		klog.Flush()
+		if err != nil {
+			os.Exit(1)
+		}
+		return
+	}
 	flag.Parse()
+	defer klog.Flush()
+	if *dnsServer != "" && *dnsZone == "" {
+		usageError("--dns-server-address requires --dns-zone")
+	}
+	if *leaseName != "" && !strings.Contains(*leaseName, "/") {
+		usageError("--leader-election-lease must be namespace/name, got %q", *leaseName)
+	}
+	if *replicas != "" && !strings.Contains(*replicas, "/") {
+		usageError("--replicas-service must be namespace/name, got %q", *replicas)
+	}
+	if *loadBalancersConfig != "" && *configPath != "" {
+		// Both would configure the load balancers of the pools.
+		usageError("--load-balancers-config can't be used with --config, move its load balancers to the configuration file")
+	}
+	if *loadBalancersConfig != "" {
+		klog.InfoS("--load-balancers-config is deprecated, use the loadBalancers and dedicated settings of --config instead")
+	}
+	if *kubeconfig != "" || *master != "" || *kubeContext != "" {
+		// Out of the cluster, the rules of the local machine are left alone and
+		// the lease of the replicas isn't taken, unless asked explicitly.
+		set := map[string]bool{}
+		flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+		if !set["dry-run"] {
+			*dryRun = true
+		}
+		if !set["leader-election-lease"] {
+			*leaseName = ""
+		}
+	}
 
 	// set up signals so we handle the first shutdown signal gracefully
 	stopCh := SetupSignalHandler()
 
-	cfg, err := rest.InClusterConfig()
+	cfg, err := buildConfig(*kubeconfig, *master, *kubeContext)
 	if err != nil {
-		panic(err)
+		klog.Exitf("Failed to build the client configuration: %s", err)
 	}
 
 	pat := clientset.NewForConfigOrDie(cfg)
 	kube := kubernetes.NewForConfigOrDie(cfg)
+	dyn := dynamic.NewForConfigOrDie(cfg)
+
+	opt := controllerOptions()
+	opt.DryRun = *dryRun
+	opt.PatClientSet = pat
+	opt.KubeClientSet = kube
+	opt.DynamicClient = dyn
 
-	patInformerFactory := informers.NewSharedInformerFactory(pat, time.Minute)
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, time.Minute)
+	resync := time.Minute
+	if opt.Config != nil && opt.Config.ResyncInterval.Duration > 0 {
+		resync = opt.Config.ResyncInterval.Duration
+	}
+	patInformerFactory := informers.NewSharedInformerFactory(pat, resync)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, resync)
 
 	patInformer := patInformerFactory.K8s().V1beta1().PortAddressTranslations()
+	clusterPatInformer := patInformerFactory.K8s().V1beta1().ClusterPortAddressTranslations()
 	coreServiceInformer := kubeInformerFactory.Core().V1().Services()
+	coreEndpointsInformer := kubeInformerFactory.Core().V1().Endpoints()
+
+	ctrl := forwarder.NewController(opt, patInformer, clusterPatInformer, coreServiceInformer, coreEndpointsInformer)
 
-	opt := forwarder.ControllerOptions{
-		LoadBalancersName: map[corev1.Protocol]string{corev1.ProtocolUDP: *udpService, corev1.ProtocolTCP: *tcpService},
-		PatClientSet:      pat,
-		KubeClientSet:     kube,
+	// The probes fail until the caches are synced and the rules configured.
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/healthz", ctrl.Healthz)
+	mux.HandleFunc("/readyz", ctrl.Readyz)
+	go func() {
+		klog.Exitf("Failed to serve the metrics: %s", http.ListenAndServe(*metricsAddress, mux))
+	}()
+
+	if *debugAddress != "" {
+		debugMux := http.NewServeMux()
+		debugMux.HandleFunc("/debug/state", ctrl.DebugState)
+		debugMux.HandleFunc("/debug/pprof/", pprof.Index)
+		debugMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+		debugMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+		debugMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+		debugMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+		go func() {
+			klog.Exitf("Failed to serve the debug endpoints: %s", http.ListenAndServe(*debugAddress, debugMux))
+		}()
 	}
-	ctrl := forwarder.NewController(opt, patInformer, coreServiceInformer)
 
 	// These are non-blocking.
-	fmt.Println("Starting informers...")
+	klog.Info("Starting informers")
 	patInformerFactory.Start(stopCh)
-	fmt.Println("Waiting for pat")
+	klog.Info("Waiting for the translations cache to sync")
 	patInformerFactory.WaitForCacheSync(stopCh)
 	kubeInformerFactory.Start(stopCh)
-	fmt.Println("Waiting for kube")
+	klog.Info("Waiting for the services and endpoints caches to sync")
 	kubeInformerFactory.WaitForCacheSync(stopCh)
 
 	ctrl.Run(stopCh)
 }
+
+// usageError prints an invalid use of the flags with the usage, and exits.
+func usageError(format string, args ...interface{}) {
+	fmt.Fprintf(flag.CommandLine.Output(), format+"\n", args...)
+	flag.Usage()
+	os.Exit(2)
+}
+
+// buildConfig returns the config of the in-cluster service account, unless a
+// kubeconfig, a master or a context is given.
+func buildConfig(kubeconfig, master, context string) (*rest.Config, error) {
+	if kubeconfig == "" && master == "" && context == "" {
+		return rest.InClusterConfig()
+	}
+
+	rules := clientcmd.NewDefaultClientConfigLoadingRules()
+	rules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	overrides.ClusterInfo.Server = master
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(rules, overrides).ClientConfig()
+}
+
+// controllerOptions returns the options of the controller set by the flags and
+// the configuration file, without the clients.
+func controllerOptions() forwarder.ControllerOptions {
+	var config *forwarder.Config
+	if *configPath != "" {
+		var err error
+		if config, err = forwarder.LoadConfig(*configPath); err != nil {
+			klog.Exitf("Failed to load the configuration: %s", err)
+		}
+	}
+
+	managedLoadBalancers := map[string]forwarder.LoadBalancerConfig{}
+	var dedicatedLoadBalancer *forwarder.LoadBalancerConfig
+	if *loadBalancersConfig != "" {
+		configs, err := forwarder.LoadLoadBalancerConfigs(*loadBalancersConfig)
+		if err != nil {
+			klog.Exitf("Failed to load the load balancers configuration: %s", err)
+		}
+		for _, config := range configs.LoadBalancers {
+			if err := loadBalancers.Set(fmt.Sprintf("%s:%s=%s", config.Pool, config.Protocol, config.Name)); err != nil {
+				klog.Exitf("Invalid load balancer %s: %s", config.Name, err)
+			}
+			managedLoadBalancers[config.Name] = config
+		}
+		dedicatedLoadBalancer = configs.Dedicated
+	}
+
+	if _, ok := loadBalancers[forwarder.DefaultLoadBalancer]; !ok {
+		loadBalancers[forwarder.DefaultLoadBalancer] = map[corev1.Protocol]string{corev1.ProtocolUDP: *udpService, corev1.ProtocolTCP: *tcpService}
+	}
+
+	return forwarder.ControllerOptions{
+		LoadBalancers:         loadBalancers,
+		ManagedLoadBalancers:  managedLoadBalancers,
+		DedicatedLoadBalancer: dedicatedLoadBalancer,
+		MixedProtocol:         *mixedProtocol,
+		DNSZone:               *dnsZone,
+		DNSServerAddress:      *dnsServer,
+		LeaderElectionLease:   *leaseName,
+		ReplicasService:       *replicas,
+		DriftInterval:         *drift,
+		DrainGracePeriod:      *drain,
+		Config:                config,
+		ConfigPath:            *configPath,
+		NflogGroup:            uint16(*nflogGroup),
+	}
+}
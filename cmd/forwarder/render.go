@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	kubeinformers "k8s.io/client-go/informers"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	kubescheme "k8s.io/client-go/kubernetes/scheme"
+
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+	patfake "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned/fake"
+	patscheme "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned/scheme"
+	informers "github.com/pdeslaur/kube-pat/pkg/client/informers/externalversions"
+	"github.com/pdeslaur/kube-pat/pkg/forwarder"
+)
+
+// manifestScheme decodes the manifests given to render.
+var manifestScheme = runtime.NewScheme()
+
+func init() {
+	utilruntime.Must(kubescheme.AddToScheme(manifestScheme))
+	utilruntime.Must(patscheme.AddToScheme(manifestScheme))
+}
+
+// render prints the IPTables rules and the load balancer changes of the
+// translations, services and endpoints read from YAML or JSON manifests,
+// without a cluster. The load balancer services missing from the manifests are
+// created when they are owned by the controller.
+func render(paths []string, out io.Writer) error {
+	if len(paths) == 0 {
+		return errors.New("usage: forwarder render [flags] FILE...")
+	}
+
+	var patObjects, kubeObjects []runtime.Object
+	for _, path := range paths {
+		objects, err := readManifests(path)
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			switch obj.(type) {
+			case *patv1beta1.PortAddressTranslation, *patv1beta1.ClusterPortAddressTranslation:
+				patObjects = append(patObjects, obj)
+			default:
+				kubeObjects = append(kubeObjects, obj)
+			}
+		}
+	}
+
+	pat := patfake.NewSimpleClientset(patObjects...)
+	kube := kubefake.NewSimpleClientset(kubeObjects...)
+	patInformerFactory := informers.NewSharedInformerFactory(pat, 0)
+	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kube, 0)
+
+	opt := controllerOptions()
+	opt.DNSServerAddress = ""
+	opt.DryRun = true
+	opt.DryRunOutput = out
+	opt.PatClientSet = pat
+	opt.KubeClientSet = kube
+	ctrl := forwarder.NewController(
+		opt,
+		patInformerFactory.K8s().V1beta1().PortAddressTranslations(),
+		patInformerFactory.K8s().V1beta1().ClusterPortAddressTranslations(),
+		kubeInformerFactory.Core().V1().Services(),
+		kubeInformerFactory.Core().V1().Endpoints(),
+	)
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	patInformerFactory.Start(stopCh)
+	patInformerFactory.WaitForCacheSync(stopCh)
+	kubeInformerFactory.Start(stopCh)
+	kubeInformerFactory.WaitForCacheSync(stopCh)
+
+	return ctrl.Render()
+}
+
+// readManifests reads the translations, services and endpoints of a file of
+// YAML documents or JSON objects.
+func readManifests(path string) ([]runtime.Object, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	decoder := serializer.NewCodecFactory(manifestScheme).UniversalDeserializer()
+	reader := yaml.NewYAMLReader(bufio.NewReader(f))
+	var objects []runtime.Object
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read %s: %s", path, err.Error())
+		}
+		if len(bytes.TrimSpace(doc)) == 0 {
+			continue
+		}
+
+		obj, gvk, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode %s: %s", path, err.Error())
+		}
+		switch obj.(type) {
+		case *patv1beta1.PortAddressTranslation, *patv1beta1.ClusterPortAddressTranslation, *corev1.Service, *corev1.Endpoints:
+			objects = append(objects, obj)
+		default:
+			return nil, fmt.Errorf("Unsupported kind %s in %s", gvk.Kind, path)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+set -o errexit
+set -o nounset
+set -o pipefail
+
+# pin checks out the submodule of the vendored package at the given version and
+# records its commit.
+pin() {
+  local path="vendor/$1"
+  if [ ! -e "${path}/.git" ]; then
+    git clone --quiet "$(git config --file .gitmodules "submodule.${path}.url")" "${path}"
+  fi
+  git -C "${path}" fetch --quiet --tags origin
+  git -C "${path}" checkout --quiet "$2"
+  git add "${path}"
+}
+
+pin k8s.io/client-go v0.20.15
+pin k8s.io/apimachinery v0.20.15
+pin github.com/coreos/go-iptables v0.4.5
+pin k8s.io/api v0.20.15
+pin k8s.io/code-generator v0.20.15
+pin github.com/florianl/go-nflog v1.1.0
+pin github.com/mdlayher/netlink v1.1.0
+pin github.com/miekg/dns v1.1.35
+pin github.com/prometheus/client_golang v1.7.1
+pin github.com/prometheus/client_model v0.2.0
+pin github.com/prometheus/common v0.10.0
+pin github.com/prometheus/procfs v0.1.3
+pin github.com/beorn7/perks v1.0.1
+pin github.com/matttproud/golang_protobuf_extensions v1.0.1
+pin k8s.io/klog/v2 v2.4.0
+pin github.com/go-logr/logr v0.2.0
+pin github.com/ti-mo/conntrack v0.3.0
+pin github.com/ti-mo/netfilter v0.3.1
@@ -4,6 +4,31 @@ const (
 	// GroupName is the name of the API.
 	GroupName = "k8s.deslauriers.io"
 
+	// OwnedPortsAnnotationKey is the annotation key attached to a load balancer
+	// service listing the names of the ports managed by the controller.
+	OwnedPortsAnnotationKey = GroupName + "/owned-ports"
+
+	// DedicatedLoadBalancerLabelKey is the label key attached to the load
+	// balancer services dedicated to a single translation.
+	DedicatedLoadBalancerLabelKey = GroupName + "/dedicated"
+
+	// DNSEndpointLabelKey is the label key attached to the DNSEndpoints
+	// published for the translations.
+	DNSEndpointLabelKey = GroupName + "/dns-endpoint"
+
+	// TranslationAnnotationKey is the annotation key attached to a dedicated
+	// load balancer service indicating the translation it belongs to.
+	TranslationAnnotationKey = GroupName + "/translation"
+
+	// Finalizer is the finalizer attached to the translations and to the load
+	// balancer services owned by the controller, removed once every replica
+	// stopped using them.
+	Finalizer = GroupName + "/cleanup"
+
+	// CleanedUpAnnotationKey is the annotation key attached to a deleted object
+	// listing the replicas which stopped using it.
+	CleanedUpAnnotationKey = GroupName + "/cleaned-up"
+
 	// ConfigurationLabelKey is the label key attached to a Revision indicating by
 	// which Configuration it is created.
 	ConfigurationLabelKey = GroupName + "/configuration"
@@ -29,6 +29,8 @@ var (
 // Adds the list of known types to Scheme.
 func addKnownTypes(scheme *runtime.Scheme) error {
 	scheme.AddKnownTypes(SchemeGroupVersion,
+		&ClusterPortAddressTranslation{},
+		&ClusterPortAddressTranslationList{},
 		&PortAddressTranslation{},
 		&PortAddressTranslationList{},
 	)
@@ -1,6 +1,7 @@
 package v1beta1
 
 import (
+	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
@@ -13,7 +14,8 @@ type PortAddressTranslation struct {
 	metav1.TypeMeta   `json:",inline"`
 	metav1.ObjectMeta `json:"metadata,omitempty"`
 
-	Spec PortAddressTranslationSpec `json:"spec"`
+	Spec   PortAddressTranslationSpec   `json:"spec"`
+	Status PortAddressTranslationStatus `json:"status,omitempty"`
 }
 
 // PortAddressTranslationSpec is the spec for a PortAddressTranslation resource
@@ -23,6 +25,69 @@ type PortAddressTranslationSpec struct {
 
 	// REQUIRED: A valid non-negative integer port number.
 	Port int32 `json:"port"`
+
+	// OPTIONAL: Name of the service receiving the traffic when Service has no
+	// ready endpoints.
+	FallbackService string `json:"fallbackService,omitempty"`
+
+	// OPTIONAL: Puts the translation in maintenance mode. Traffic is sent to
+	// MaintenanceService if set, otherwise it is rejected. Service isn't
+	// required while suspended, except for its protocol when neither
+	// MaintenanceService nor Protocol are set.
+	Suspend bool `json:"suspend,omitempty"`
+
+	// OPTIONAL: Name of the service receiving the traffic while suspended.
+	MaintenanceService string `json:"maintenanceService,omitempty"`
+
+	// OPTIONAL: Protocol of the translation, TCP or UDP. The protocol of the
+	// services must match it. Required to reject the traffic while suspended
+	// without MaintenanceService when Service doesn't exist.
+	Protocol corev1.Protocol `json:"protocol,omitempty"`
+
+	// OPTIONAL: Limits applied to the incoming traffic.
+	Limits *Limits `json:"limits,omitempty"`
+
+	// OPTIONAL: Logs every new connection.
+	Logging bool `json:"logging,omitempty"`
+
+	// OPTIONAL: Name of the load balancer pool publishing the port. The
+	// default pool is used when empty.
+	LoadBalancer string `json:"loadBalancer,omitempty"`
+
+	// OPTIONAL: Publishes the port on a load balancer dedicated to this
+	// translation instead of a load balancer pool. The load balancer sends
+	// the traffic to a target port reserved for the translation.
+	DedicatedLoadBalancer bool `json:"dedicatedLoadBalancer,omitempty"`
+
+	// OPTIONAL: External IP the translation is bound to. The translation
+	// matches the traffic sent to any IP when empty. Several translations can
+	// use the same port on different IPs. The IP must be assigned to the
+	// forwarders, like a virtual IP of forwarders on the host network. A load
+	// balancer service translates the destination of its traffic to the pod
+	// IP of a forwarder, which never matches a bound translation.
+	Address string `json:"address,omitempty"`
+}
+
+// Limits restricts the traffic accepted by a PortAddressTranslation. Traffic
+// above the limits is dropped. A zero value disables the limit.
+type Limits struct {
+	// OPTIONAL: Maximum number of concurrent connections per source IP.
+	ConnectionsPerSource int32 `json:"connectionsPerSource,omitempty"`
+
+	// OPTIONAL: Maximum number of new connections per second per source IP.
+	NewConnectionsPerSecond int32 `json:"newConnectionsPerSecond,omitempty"`
+
+	// OPTIONAL: Maximum number of packets per second per source IP.
+	PacketsPerSecond int32 `json:"packetsPerSecond,omitempty"`
+}
+
+// PortAddressTranslationStatus is the observed state of a translation
+type PortAddressTranslationStatus struct {
+	// External endpoints of the translation, as host:port.
+	Endpoints []string `json:"endpoints,omitempty"`
+
+	// DNS name of the translation, when DNS records are published.
+	Hostname string `json:"hostname,omitempty"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -34,3 +99,37 @@ type PortAddressTranslationList struct {
 
 	Items []PortAddressTranslation `json:"items"`
 }
+
+// +genclient
+// +genclient:nonNamespaced
+// +genclient:noStatus
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPortAddressTranslation describes a port address translation owned by
+// the cluster administrators. Its port takes precedence over the port of any
+// PortAddressTranslation.
+type ClusterPortAddressTranslation struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   ClusterPortAddressTranslationSpec `json:"spec"`
+	Status PortAddressTranslationStatus      `json:"status,omitempty"`
+}
+
+// ClusterPortAddressTranslationSpec is the spec for a ClusterPortAddressTranslation resource
+type ClusterPortAddressTranslationSpec struct {
+	// REQUIRED: Namespace of the services to map
+	Namespace string `json:"namespace"`
+
+	PortAddressTranslationSpec `json:",inline"`
+}
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ClusterPortAddressTranslationList is a list of ClusterPortAddressTranslation resources
+type ClusterPortAddressTranslationList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata"`
+
+	Items []ClusterPortAddressTranslation `json:"items"`
+}
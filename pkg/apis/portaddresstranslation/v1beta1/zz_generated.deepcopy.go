@@ -24,12 +24,107 @@ import (
 	runtime "k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPortAddressTranslation) DeepCopyInto(out *ClusterPortAddressTranslation) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPortAddressTranslation.
+func (in *ClusterPortAddressTranslation) DeepCopy() *ClusterPortAddressTranslation {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPortAddressTranslation)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterPortAddressTranslation) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPortAddressTranslationList) DeepCopyInto(out *ClusterPortAddressTranslationList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	out.ListMeta = in.ListMeta
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]ClusterPortAddressTranslation, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPortAddressTranslationList.
+func (in *ClusterPortAddressTranslationList) DeepCopy() *ClusterPortAddressTranslationList {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPortAddressTranslationList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *ClusterPortAddressTranslationList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ClusterPortAddressTranslationSpec) DeepCopyInto(out *ClusterPortAddressTranslationSpec) {
+	*out = *in
+	in.PortAddressTranslationSpec.DeepCopyInto(&out.PortAddressTranslationSpec)
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ClusterPortAddressTranslationSpec.
+func (in *ClusterPortAddressTranslationSpec) DeepCopy() *ClusterPortAddressTranslationSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(ClusterPortAddressTranslationSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *Limits) DeepCopyInto(out *Limits) {
+	*out = *in
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new Limits.
+func (in *Limits) DeepCopy() *Limits {
+	if in == nil {
+		return nil
+	}
+	out := new(Limits)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PortAddressTranslation) DeepCopyInto(out *PortAddressTranslation) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
-	out.Spec = in.Spec
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
 	return
 }
 
@@ -87,6 +182,11 @@ func (in *PortAddressTranslationList) DeepCopyObject() runtime.Object {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *PortAddressTranslationSpec) DeepCopyInto(out *PortAddressTranslationSpec) {
 	*out = *in
+	if in.Limits != nil {
+		in, out := &in.Limits, &out.Limits
+		*out = new(Limits)
+		**out = **in
+	}
 	return
 }
 
@@ -99,3 +199,24 @@ func (in *PortAddressTranslationSpec) DeepCopy() *PortAddressTranslationSpec {
 	in.DeepCopyInto(out)
 	return out
 }
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *PortAddressTranslationStatus) DeepCopyInto(out *PortAddressTranslationStatus) {
+	*out = *in
+	if in.Endpoints != nil {
+		in, out := &in.Endpoints, &out.Endpoints
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	return
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new PortAddressTranslationStatus.
+func (in *PortAddressTranslationStatus) DeepCopy() *PortAddressTranslationStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(PortAddressTranslationStatus)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -19,6 +19,8 @@ limitations under the License.
 package fake
 
 import (
+	"context"
+
 	v1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	labels "k8s.io/apimachinery/pkg/labels"
@@ -39,7 +41,7 @@ var portaddresstranslationsResource = schema.GroupVersionResource{Group: "k8s.de
 var portaddresstranslationsKind = schema.GroupVersionKind{Group: "k8s.deslauriers.io", Version: "v1beta1", Kind: "PortAddressTranslation"}
 
 // Get takes name of the portAddressTranslation, and returns the corresponding portAddressTranslation object, and an error if there is any.
-func (c *FakePortAddressTranslations) Get(name string, options v1.GetOptions) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *FakePortAddressTranslations) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.PortAddressTranslation, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewGetAction(portaddresstranslationsResource, c.ns, name), &v1beta1.PortAddressTranslation{})
 
@@ -50,7 +52,7 @@ func (c *FakePortAddressTranslations) Get(name string, options v1.GetOptions) (r
 }
 
 // List takes label and field selectors, and returns the list of PortAddressTranslations that match those selectors.
-func (c *FakePortAddressTranslations) List(opts v1.ListOptions) (result *v1beta1.PortAddressTranslationList, err error) {
+func (c *FakePortAddressTranslations) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.PortAddressTranslationList, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewListAction(portaddresstranslationsResource, portaddresstranslationsKind, c.ns, opts), &v1beta1.PortAddressTranslationList{})
 
@@ -72,14 +74,14 @@ func (c *FakePortAddressTranslations) List(opts v1.ListOptions) (result *v1beta1
 }
 
 // Watch returns a watch.Interface that watches the requested portAddressTranslations.
-func (c *FakePortAddressTranslations) Watch(opts v1.ListOptions) (watch.Interface, error) {
+func (c *FakePortAddressTranslations) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
 	return c.Fake.
 		InvokesWatch(testing.NewWatchAction(portaddresstranslationsResource, c.ns, opts))
 
 }
 
 // Create takes the representation of a portAddressTranslation and creates it.  Returns the server's representation of the portAddressTranslation, and an error, if there is any.
-func (c *FakePortAddressTranslations) Create(portAddressTranslation *v1beta1.PortAddressTranslation) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *FakePortAddressTranslations) Create(ctx context.Context, portAddressTranslation *v1beta1.PortAddressTranslation, opts v1.CreateOptions) (result *v1beta1.PortAddressTranslation, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewCreateAction(portaddresstranslationsResource, c.ns, portAddressTranslation), &v1beta1.PortAddressTranslation{})
 
@@ -90,7 +92,7 @@ func (c *FakePortAddressTranslations) Create(portAddressTranslation *v1beta1.Por
 }
 
 // Update takes the representation of a portAddressTranslation and updates it. Returns the server's representation of the portAddressTranslation, and an error, if there is any.
-func (c *FakePortAddressTranslations) Update(portAddressTranslation *v1beta1.PortAddressTranslation) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *FakePortAddressTranslations) Update(ctx context.Context, portAddressTranslation *v1beta1.PortAddressTranslation, opts v1.UpdateOptions) (result *v1beta1.PortAddressTranslation, err error) {
 	obj, err := c.Fake.
 		Invokes(testing.NewUpdateAction(portaddresstranslationsResource, c.ns, portAddressTranslation), &v1beta1.PortAddressTranslation{})
 
@@ -101,7 +103,7 @@ func (c *FakePortAddressTranslations) Update(portAddressTranslation *v1beta1.Por
 }
 
 // Delete takes name of the portAddressTranslation and deletes it. Returns an error if one occurs.
-func (c *FakePortAddressTranslations) Delete(name string, options *v1.DeleteOptions) error {
+func (c *FakePortAddressTranslations) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	_, err := c.Fake.
 		Invokes(testing.NewDeleteAction(portaddresstranslationsResource, c.ns, name), &v1beta1.PortAddressTranslation{})
 
@@ -109,17 +111,17 @@ func (c *FakePortAddressTranslations) Delete(name string, options *v1.DeleteOpti
 }
 
 // DeleteCollection deletes a collection of objects.
-func (c *FakePortAddressTranslations) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
-	action := testing.NewDeleteCollectionAction(portaddresstranslationsResource, c.ns, listOptions)
+func (c *FakePortAddressTranslations) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	action := testing.NewDeleteCollectionAction(portaddresstranslationsResource, c.ns, listOpts)
 
 	_, err := c.Fake.Invokes(action, &v1beta1.PortAddressTranslationList{})
 	return err
 }
 
 // Patch applies the patch and returns the patched portAddressTranslation.
-func (c *FakePortAddressTranslations) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *FakePortAddressTranslations) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PortAddressTranslation, err error) {
 	obj, err := c.Fake.
-		Invokes(testing.NewPatchSubresourceAction(portaddresstranslationsResource, c.ns, name, data, subresources...), &v1beta1.PortAddressTranslation{})
+		Invokes(testing.NewPatchSubresourceAction(portaddresstranslationsResource, c.ns, name, pt, data, subresources...), &v1beta1.PortAddressTranslation{})
 
 	if obj == nil {
 		return nil, err
@@ -28,6 +28,10 @@ type FakeK8sV1beta1 struct {
 	*testing.Fake
 }
 
+func (c *FakeK8sV1beta1) ClusterPortAddressTranslations() v1beta1.ClusterPortAddressTranslationInterface {
+	return &FakeClusterPortAddressTranslations{c}
+}
+
 func (c *FakeK8sV1beta1) PortAddressTranslations(namespace string) v1beta1.PortAddressTranslationInterface {
 	return &FakePortAddressTranslations{c, namespace}
 }
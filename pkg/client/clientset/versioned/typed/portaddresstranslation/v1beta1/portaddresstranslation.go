@@ -19,6 +19,9 @@ limitations under the License.
 package v1beta1
 
 import (
+	"context"
+	"time"
+
 	v1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
 	scheme "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned/scheme"
 	v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -35,14 +38,14 @@ type PortAddressTranslationsGetter interface {
 
 // PortAddressTranslationInterface has methods to work with PortAddressTranslation resources.
 type PortAddressTranslationInterface interface {
-	Create(*v1beta1.PortAddressTranslation) (*v1beta1.PortAddressTranslation, error)
-	Update(*v1beta1.PortAddressTranslation) (*v1beta1.PortAddressTranslation, error)
-	Delete(name string, options *v1.DeleteOptions) error
-	DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error
-	Get(name string, options v1.GetOptions) (*v1beta1.PortAddressTranslation, error)
-	List(opts v1.ListOptions) (*v1beta1.PortAddressTranslationList, error)
-	Watch(opts v1.ListOptions) (watch.Interface, error)
-	Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PortAddressTranslation, err error)
+	Create(ctx context.Context, portAddressTranslation *v1beta1.PortAddressTranslation, opts v1.CreateOptions) (*v1beta1.PortAddressTranslation, error)
+	Update(ctx context.Context, portAddressTranslation *v1beta1.PortAddressTranslation, opts v1.UpdateOptions) (*v1beta1.PortAddressTranslation, error)
+	Delete(ctx context.Context, name string, opts v1.DeleteOptions) error
+	DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error
+	Get(ctx context.Context, name string, opts v1.GetOptions) (*v1beta1.PortAddressTranslation, error)
+	List(ctx context.Context, opts v1.ListOptions) (*v1beta1.PortAddressTranslationList, error)
+	Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error)
+	Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PortAddressTranslation, err error)
 	PortAddressTranslationExpansion
 }
 
@@ -61,97 +64,115 @@ func newPortAddressTranslations(c *K8sV1beta1Client, namespace string) *portAddr
 }
 
 // Get takes name of the portAddressTranslation, and returns the corresponding portAddressTranslation object, and an error if there is any.
-func (c *portAddressTranslations) Get(name string, options v1.GetOptions) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *portAddressTranslations) Get(ctx context.Context, name string, options v1.GetOptions) (result *v1beta1.PortAddressTranslation, err error) {
 	result = &v1beta1.PortAddressTranslation{}
 	err = c.client.Get().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
 		Name(name).
 		VersionedParams(&options, scheme.ParameterCodec).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // List takes label and field selectors, and returns the list of PortAddressTranslations that match those selectors.
-func (c *portAddressTranslations) List(opts v1.ListOptions) (result *v1beta1.PortAddressTranslationList, err error) {
+func (c *portAddressTranslations) List(ctx context.Context, opts v1.ListOptions) (result *v1beta1.PortAddressTranslationList, err error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
 	result = &v1beta1.PortAddressTranslationList{}
 	err = c.client.Get().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
 		VersionedParams(&opts, scheme.ParameterCodec).
-		Do().
+		Timeout(timeout).
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // Watch returns a watch.Interface that watches the requested portAddressTranslations.
-func (c *portAddressTranslations) Watch(opts v1.ListOptions) (watch.Interface, error) {
+func (c *portAddressTranslations) Watch(ctx context.Context, opts v1.ListOptions) (watch.Interface, error) {
+	var timeout time.Duration
+	if opts.TimeoutSeconds != nil {
+		timeout = time.Duration(*opts.TimeoutSeconds) * time.Second
+	}
 	opts.Watch = true
 	return c.client.Get().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
 		VersionedParams(&opts, scheme.ParameterCodec).
-		Watch()
+		Timeout(timeout).
+		Watch(ctx)
 }
 
 // Create takes the representation of a portAddressTranslation and creates it.  Returns the server's representation of the portAddressTranslation, and an error, if there is any.
-func (c *portAddressTranslations) Create(portAddressTranslation *v1beta1.PortAddressTranslation) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *portAddressTranslations) Create(ctx context.Context, portAddressTranslation *v1beta1.PortAddressTranslation, opts v1.CreateOptions) (result *v1beta1.PortAddressTranslation, err error) {
 	result = &v1beta1.PortAddressTranslation{}
 	err = c.client.Post().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
+		VersionedParams(&opts, scheme.ParameterCodec).
 		Body(portAddressTranslation).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // Update takes the representation of a portAddressTranslation and updates it. Returns the server's representation of the portAddressTranslation, and an error, if there is any.
-func (c *portAddressTranslations) Update(portAddressTranslation *v1beta1.PortAddressTranslation) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *portAddressTranslations) Update(ctx context.Context, portAddressTranslation *v1beta1.PortAddressTranslation, opts v1.UpdateOptions) (result *v1beta1.PortAddressTranslation, err error) {
 	result = &v1beta1.PortAddressTranslation{}
 	err = c.client.Put().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
 		Name(portAddressTranslation.Name).
+		VersionedParams(&opts, scheme.ParameterCodec).
 		Body(portAddressTranslation).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
 
 // Delete takes name of the portAddressTranslation and deletes it. Returns an error if one occurs.
-func (c *portAddressTranslations) Delete(name string, options *v1.DeleteOptions) error {
+func (c *portAddressTranslations) Delete(ctx context.Context, name string, opts v1.DeleteOptions) error {
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
 		Name(name).
-		Body(options).
-		Do().
+		Body(&opts).
+		Do(ctx).
 		Error()
 }
 
 // DeleteCollection deletes a collection of objects.
-func (c *portAddressTranslations) DeleteCollection(options *v1.DeleteOptions, listOptions v1.ListOptions) error {
+func (c *portAddressTranslations) DeleteCollection(ctx context.Context, opts v1.DeleteOptions, listOpts v1.ListOptions) error {
+	var timeout time.Duration
+	if listOpts.TimeoutSeconds != nil {
+		timeout = time.Duration(*listOpts.TimeoutSeconds) * time.Second
+	}
 	return c.client.Delete().
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
-		VersionedParams(&listOptions, scheme.ParameterCodec).
-		Body(options).
-		Do().
+		VersionedParams(&listOpts, scheme.ParameterCodec).
+		Timeout(timeout).
+		Body(&opts).
+		Do(ctx).
 		Error()
 }
 
 // Patch applies the patch and returns the patched portAddressTranslation.
-func (c *portAddressTranslations) Patch(name string, pt types.PatchType, data []byte, subresources ...string) (result *v1beta1.PortAddressTranslation, err error) {
+func (c *portAddressTranslations) Patch(ctx context.Context, name string, pt types.PatchType, data []byte, opts v1.PatchOptions, subresources ...string) (result *v1beta1.PortAddressTranslation, err error) {
 	result = &v1beta1.PortAddressTranslation{}
 	err = c.client.Patch(pt).
 		Namespace(c.ns).
 		Resource("portaddresstranslations").
-		SubResource(subresources...).
 		Name(name).
+		SubResource(subresources...).
+		VersionedParams(&opts, scheme.ParameterCodec).
 		Body(data).
-		Do().
+		Do(ctx).
 		Into(result)
 	return
 }
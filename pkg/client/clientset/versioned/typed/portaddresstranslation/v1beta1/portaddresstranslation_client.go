@@ -21,12 +21,12 @@ package v1beta1
 import (
 	v1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
 	"github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned/scheme"
-	serializer "k8s.io/apimachinery/pkg/runtime/serializer"
 	rest "k8s.io/client-go/rest"
 )
 
 type K8sV1beta1Interface interface {
 	RESTClient() rest.Interface
+	ClusterPortAddressTranslationsGetter
 	PortAddressTranslationsGetter
 }
 
@@ -35,6 +35,10 @@ type K8sV1beta1Client struct {
 	restClient rest.Interface
 }
 
+func (c *K8sV1beta1Client) ClusterPortAddressTranslations() ClusterPortAddressTranslationInterface {
+	return newClusterPortAddressTranslations(c)
+}
+
 func (c *K8sV1beta1Client) PortAddressTranslations(namespace string) PortAddressTranslationInterface {
 	return newPortAddressTranslations(c, namespace)
 }
@@ -71,7 +75,7 @@ func setConfigDefaults(config *rest.Config) error {
 	gv := v1beta1.SchemeGroupVersion
 	config.GroupVersion = &gv
 	config.APIPath = "/apis"
-	config.NegotiatedSerializer = serializer.DirectCodecFactory{CodecFactory: scheme.Codecs}
+	config.NegotiatedSerializer = scheme.Codecs.WithoutConversion()
 
 	if config.UserAgent == "" {
 		config.UserAgent = rest.DefaultKubernetesUserAgent()
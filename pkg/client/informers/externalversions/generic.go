@@ -53,6 +53,8 @@ func (f *genericInformer) Lister() cache.GenericLister {
 func (f *sharedInformerFactory) ForResource(resource schema.GroupVersionResource) (GenericInformer, error) {
 	switch resource {
 	// Group=k8s.deslauriers.io, Version=v1beta1
+	case v1beta1.SchemeGroupVersion.WithResource("clusterportaddresstranslations"):
+		return &genericInformer{resource: resource.GroupResource(), informer: f.K8s().V1beta1().ClusterPortAddressTranslations().Informer()}, nil
 	case v1beta1.SchemeGroupVersion.WithResource("portaddresstranslations"):
 		return &genericInformer{resource: resource.GroupResource(), informer: f.K8s().V1beta1().PortAddressTranslations().Informer()}, nil
 
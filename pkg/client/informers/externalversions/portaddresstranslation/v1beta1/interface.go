@@ -24,6 +24,8 @@ import (
 
 // Interface provides access to all the informers in this group version.
 type Interface interface {
+	// ClusterPortAddressTranslations returns a ClusterPortAddressTranslationInformer.
+	ClusterPortAddressTranslations() ClusterPortAddressTranslationInformer
 	// PortAddressTranslations returns a PortAddressTranslationInformer.
 	PortAddressTranslations() PortAddressTranslationInformer
 }
@@ -39,6 +41,11 @@ func New(f internalinterfaces.SharedInformerFactory, namespace string, tweakList
 	return &version{factory: f, namespace: namespace, tweakListOptions: tweakListOptions}
 }
 
+// ClusterPortAddressTranslations returns a ClusterPortAddressTranslationInformer.
+func (v *version) ClusterPortAddressTranslations() ClusterPortAddressTranslationInformer {
+	return &clusterPortAddressTranslationInformer{factory: v.factory, tweakListOptions: v.tweakListOptions}
+}
+
 // PortAddressTranslations returns a PortAddressTranslationInformer.
 func (v *version) PortAddressTranslations() PortAddressTranslationInformer {
 	return &portAddressTranslationInformer{factory: v.factory, namespace: v.namespace, tweakListOptions: v.tweakListOptions}
@@ -19,6 +19,7 @@ limitations under the License.
 package v1beta1
 
 import (
+	"context"
 	time "time"
 
 	portaddresstranslationv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
@@ -61,13 +62,13 @@ func NewFilteredPortAddressTranslationInformer(client versioned.Interface, names
 				if tweakListOptions != nil {
 					tweakListOptions(&options)
 				}
-				return client.K8sV1beta1().PortAddressTranslations(namespace).List(options)
+				return client.K8sV1beta1().PortAddressTranslations(namespace).List(context.TODO(), options)
 			},
 			WatchFunc: func(options v1.ListOptions) (watch.Interface, error) {
 				if tweakListOptions != nil {
 					tweakListOptions(&options)
 				}
-				return client.K8sV1beta1().PortAddressTranslations(namespace).Watch(options)
+				return client.K8sV1beta1().PortAddressTranslations(namespace).Watch(context.TODO(), options)
 			},
 		},
 		&portaddresstranslationv1beta1.PortAddressTranslation{},
@@ -18,6 +18,10 @@ limitations under the License.
 
 package v1beta1
 
+// ClusterPortAddressTranslationListerExpansion allows custom methods to be added to
+// ClusterPortAddressTranslationLister.
+type ClusterPortAddressTranslationListerExpansion interface{}
+
 // PortAddressTranslationListerExpansion allows custom methods to be added to
 // PortAddressTranslationLister.
 type PortAddressTranslationListerExpansion interface{}
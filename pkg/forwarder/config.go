@@ -0,0 +1,250 @@
+package forwarder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+)
+
+// ConfigVersion is the version of the format of the configuration file.
+const ConfigVersion = "kube-pat/v1"
+
+// configPollInterval is the interval between the reads of the configuration
+// file. A ConfigMap mounted as a volume is updated by swapping a symlink, the
+// file is read again rather than watched.
+const configPollInterval = 10 * time.Second
+
+// IPTablesBackend is the backend programming the translations with IPTables,
+// the traffic is translated in the nat table. It is the only backend.
+const IPTablesBackend = "iptables"
+
+// Config is the content of the configuration file of the controller. Its
+// fields override the flags when set. A file with unknown fields is rejected.
+type Config struct {
+	// Version is the version of the format, see ConfigVersion.
+	Version string `json:"version"`
+
+	// Backend programs the translations on the nodes, IPTablesBackend when
+	// empty. Other backends are rejected.
+	Backend string `json:"backend,omitempty"`
+
+	// Interfaces are the network interfaces receiving the translated traffic
+	// and sending the forwarded traffic.
+	Interfaces []string `json:"interfaces,omitempty"`
+
+	// ResyncInterval is the interval between the full resynchronizations of
+	// the caches. A reload changing it is rejected, it is applied on restart.
+	ResyncInterval metav1.Duration `json:"resyncInterval,omitempty"`
+
+	// LoadBalancerPools are the names of the load balancer services per
+	// protocol, for each load balancer pool, as namespace/name. The services
+	// described by LoadBalancers are added to their pool.
+	LoadBalancerPools map[string]map[corev1.Protocol]string `json:"loadBalancerPools,omitempty"`
+
+	// LoadBalancerConfigs are the load balancer services created and owned by
+	// the controller.
+	LoadBalancerConfigs
+
+	// PortPools are the ports the translations of a load balancer pool may
+	// use, per pool. The translations of a pool without range may use any
+	// port.
+	PortPools map[string]PortRange `json:"portPools,omitempty"`
+
+	// DrainGracePeriod is the time the established flows are forwarded after a
+	// shutdown signal or the removal of a translation.
+	DrainGracePeriod *metav1.Duration `json:"drainGracePeriod,omitempty"`
+
+	// Features toggles the optional features.
+	Features Features `json:"features"`
+}
+
+// Features are the optional features of the controller.
+type Features struct {
+	// MixedProtocol publishes the UDP ports of a pool on its TCP load balancer
+	// service, see ControllerOptions.MixedProtocol.
+	MixedProtocol *bool `json:"mixedProtocol,omitempty"`
+
+	// ConnectionLogging logs the connections of the translations requesting
+	// it, enabled when not set. See ControllerOptions.DisableConnectionLogging.
+	ConnectionLogging *bool `json:"connectionLogging,omitempty"`
+
+	// ConntrackCleanup deletes the established flows of the removed and
+	// retargeted translations, enabled when not set. See
+	// ControllerOptions.DisableConntrackCleanup.
+	ConntrackCleanup *bool `json:"conntrackCleanup,omitempty"`
+}
+
+// LoadConfig reads a YAML or JSON configuration file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if data, err = yaml.ToJSON(data); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+
+	// The misspelled or unsupported settings are rejected rather than ignored.
+	var config Config
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err = decoder.Decode(&config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	if config.Version != ConfigVersion {
+		return nil, fmt.Errorf("unsupported version %q in %s, expected %s", config.Version, path, ConfigVersion)
+	}
+	if config.Backend != "" && config.Backend != IPTablesBackend {
+		return nil, fmt.Errorf("unsupported backend %q in %s, only %s is supported", config.Backend, path, IPTablesBackend)
+	}
+	if config.ResyncInterval.Duration < 0 {
+		return nil, fmt.Errorf("negative resync interval in %s", path)
+	}
+	for _, nic := range config.Interfaces {
+		if nic == "" {
+			return nil, fmt.Errorf("empty interface name in %s", path)
+		}
+	}
+	for pool, services := range config.LoadBalancerPools {
+		for protocol, name := range services {
+			if protocol != corev1.ProtocolTCP && protocol != corev1.ProtocolUDP {
+				return nil, fmt.Errorf("load balancer pool %s in %s has unsupported protocol %q", pool, path, protocol)
+			}
+			if _, _, err := splitName(name); err != nil {
+				return nil, fmt.Errorf("load balancer %q of pool %s in %s must be namespace/name", name, pool, path)
+			}
+		}
+	}
+	for pool, ports := range config.PortPools {
+		if ports.First < 1 || ports.First > ports.Last || ports.Last > 65535 {
+			return nil, fmt.Errorf("invalid ports %d-%d of load balancer pool %s in %s", ports.First, ports.Last, pool, path)
+		}
+	}
+	if err = config.LoadBalancerConfigs.validate(path); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// apply overrides the options set by the configuration. The load balancer
+// pools of the configuration replace the ones of the options, except the
+// default pool which is kept when not configured.
+func (config Config) apply(opt *ControllerOptions) {
+	if len(config.Interfaces) > 0 {
+		opt.Interfaces = config.Interfaces
+	}
+
+	if len(config.LoadBalancerPools) > 0 || len(config.LoadBalancers) > 0 {
+		pools := map[string]map[corev1.Protocol]string{}
+		for pool, services := range config.LoadBalancerPools {
+			pools[pool] = map[corev1.Protocol]string{}
+			for protocol, name := range services {
+				pools[pool][protocol] = name
+			}
+		}
+		managed := map[string]LoadBalancerConfig{}
+		for _, lb := range config.LoadBalancers {
+			if pools[lb.Pool] == nil {
+				pools[lb.Pool] = map[corev1.Protocol]string{}
+			}
+			pools[lb.Pool][lb.Protocol] = lb.Name
+			managed[lb.Name] = lb
+		}
+		if _, ok := pools[DefaultLoadBalancer]; !ok && opt.LoadBalancers[DefaultLoadBalancer] != nil {
+			pools[DefaultLoadBalancer] = opt.LoadBalancers[DefaultLoadBalancer]
+		}
+		opt.LoadBalancers = pools
+		opt.ManagedLoadBalancers = managed
+	}
+	if config.Dedicated != nil {
+		opt.DedicatedLoadBalancer = config.Dedicated
+	}
+
+	if config.DrainGracePeriod != nil {
+		opt.DrainGracePeriod = config.DrainGracePeriod.Duration
+	}
+	if config.PortPools != nil {
+		opt.PortPools = config.PortPools
+	}
+	if config.Features.MixedProtocol != nil {
+		opt.MixedProtocol = *config.Features.MixedProtocol
+	}
+	if config.Features.ConnectionLogging != nil {
+		opt.DisableConnectionLogging = !*config.Features.ConnectionLogging
+	}
+	if config.Features.ConntrackCleanup != nil {
+		opt.DisableConntrackCleanup = !*config.Features.ConntrackCleanup
+	}
+}
+
+// runConfigReload reads the configuration file every configPollInterval until
+// stopCh is closed, and applies it when it changed.
+func (c Controller) runConfigReload(stopCh <-chan struct{}) {
+	last := c.base.Config
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+		}
+
+		config, err := LoadConfig(c.base.ConfigPath)
+		if err != nil {
+			// The previous configuration is kept.
+			klog.ErrorS(err, "Failed to reload the configuration", "path", c.base.ConfigPath)
+			configReloadsTotal.WithLabelValues(result(err)).Inc()
+			continue
+		}
+		if reflect.DeepEqual(config, last) {
+			continue
+		}
+
+		err = c.reloadConfig(config)
+		configReloadsTotal.WithLabelValues(result(err)).Inc()
+		if err != nil {
+			klog.ErrorS(err, "Failed to apply the configuration", "path", c.base.ConfigPath)
+			continue
+		}
+		klog.InfoS("Reloaded the configuration", "path", c.base.ConfigPath)
+		last = config
+		c.Refresh(c.s)
+	}
+}
+
+// reloadConfig applies a configuration over the options given to the
+// controller.
+func (c Controller) reloadConfig(config *Config) error {
+	// The informers are created with the resync interval of the first
+	// configuration.
+	if previous := c.options().Config; previous != nil && config.ResyncInterval != previous.ResyncInterval {
+		return fmt.Errorf("the resync interval can't change from %s to %s without a restart", previous.ResyncInterval.Duration, config.ResyncInterval.Duration)
+	}
+
+	opt := c.base
+	opt.Config = config
+	config.apply(&opt)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	current := c.options()
+	if !reflect.DeepEqual(opt.Interfaces, current.Interfaces) {
+		if err := c.pf.SetInterfaces(opt.Interfaces); err != nil {
+			return err
+		}
+	}
+	if opt.MixedProtocol != current.MixedProtocol {
+		c.mixedProtocol.Store(opt.MixedProtocol)
+	}
+	c.opt.Store(opt)
+	return nil
+}
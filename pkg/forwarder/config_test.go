@@ -0,0 +1,219 @@
+package forwarder
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestConfigApply(t *testing.T) {
+	enabled, disabled := true, false
+	defaultPool := map[corev1.Protocol]string{corev1.ProtocolTCP: "kube-pat/default-tcp"}
+	dedicated := &LoadBalancerConfig{Name: "kube-pat/dedicated"}
+	managed := LoadBalancerConfig{Pool: "edge", Protocol: corev1.ProtocolUDP, Name: "kube-pat/edge-udp"}
+
+	base := ControllerOptions{
+		Interfaces:       []string{"eth0"},
+		LoadBalancers:    map[string]map[corev1.Protocol]string{DefaultLoadBalancer: defaultPool},
+		DrainGracePeriod: 10 * time.Second,
+	}
+
+	tests := []struct {
+		name   string
+		config Config
+		want   ControllerOptions
+	}{
+		{
+			name:   "empty configuration",
+			config: Config{},
+			want:   base,
+		},
+		{
+			name:   "interfaces",
+			config: Config{Interfaces: []string{"eth1", "eth2"}},
+			want: ControllerOptions{
+				Interfaces:       []string{"eth1", "eth2"},
+				LoadBalancers:    base.LoadBalancers,
+				DrainGracePeriod: base.DrainGracePeriod,
+			},
+		},
+		{
+			name: "pools replace the options but keep the default pool",
+			config: Config{LoadBalancerPools: map[string]map[corev1.Protocol]string{
+				"edge": {corev1.ProtocolTCP: "kube-pat/edge-tcp"},
+			}},
+			want: ControllerOptions{
+				Interfaces: base.Interfaces,
+				LoadBalancers: map[string]map[corev1.Protocol]string{
+					DefaultLoadBalancer: defaultPool,
+					"edge":              {corev1.ProtocolTCP: "kube-pat/edge-tcp"},
+				},
+				ManagedLoadBalancers: map[string]LoadBalancerConfig{},
+				DrainGracePeriod:     base.DrainGracePeriod,
+			},
+		},
+		{
+			name: "configured default pool",
+			config: Config{LoadBalancerPools: map[string]map[corev1.Protocol]string{
+				DefaultLoadBalancer: {corev1.ProtocolUDP: "kube-pat/default-udp"},
+			}},
+			want: ControllerOptions{
+				Interfaces: base.Interfaces,
+				LoadBalancers: map[string]map[corev1.Protocol]string{
+					DefaultLoadBalancer: {corev1.ProtocolUDP: "kube-pat/default-udp"},
+				},
+				ManagedLoadBalancers: map[string]LoadBalancerConfig{},
+				DrainGracePeriod:     base.DrainGracePeriod,
+			},
+		},
+		{
+			name: "managed load balancers are added to their pool",
+			config: Config{
+				LoadBalancerPools: map[string]map[corev1.Protocol]string{
+					"edge": {corev1.ProtocolTCP: "kube-pat/edge-tcp"},
+				},
+				LoadBalancerConfigs: LoadBalancerConfigs{LoadBalancers: []LoadBalancerConfig{managed}},
+			},
+			want: ControllerOptions{
+				Interfaces: base.Interfaces,
+				LoadBalancers: map[string]map[corev1.Protocol]string{
+					DefaultLoadBalancer: defaultPool,
+					"edge": {
+						corev1.ProtocolTCP: "kube-pat/edge-tcp",
+						corev1.ProtocolUDP: "kube-pat/edge-udp",
+					},
+				},
+				ManagedLoadBalancers: map[string]LoadBalancerConfig{"kube-pat/edge-udp": managed},
+				DrainGracePeriod:     base.DrainGracePeriod,
+			},
+		},
+		{
+			name: "dedicated load balancers, drain grace period and mixed protocol",
+			config: Config{
+				LoadBalancerConfigs: LoadBalancerConfigs{Dedicated: dedicated},
+				DrainGracePeriod:    &metav1.Duration{Duration: time.Minute},
+				Features:            Features{MixedProtocol: &enabled},
+			},
+			want: ControllerOptions{
+				Interfaces:            base.Interfaces,
+				LoadBalancers:         base.LoadBalancers,
+				DedicatedLoadBalancer: dedicated,
+				DrainGracePeriod:      time.Minute,
+				MixedProtocol:         true,
+			},
+		},
+		{
+			name: "port pools and disabled features",
+			config: Config{
+				PortPools: map[string]PortRange{DefaultLoadBalancer: {First: 1, Last: 1023}},
+				Features:  Features{ConnectionLogging: &disabled, ConntrackCleanup: &disabled},
+			},
+			want: ControllerOptions{
+				Interfaces:               base.Interfaces,
+				LoadBalancers:            base.LoadBalancers,
+				DrainGracePeriod:         base.DrainGracePeriod,
+				PortPools:                map[string]PortRange{DefaultLoadBalancer: {First: 1, Last: 1023}},
+				DisableConnectionLogging: true,
+				DisableConntrackCleanup:  true,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := base
+			tt.config.apply(&opt)
+			if !reflect.DeepEqual(opt, tt.want) {
+				t.Errorf("options = %+v, want %+v", opt, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kube-pat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+	}{
+		{
+			name:    "YAML",
+			content: "version: kube-pat/v1\nbackend: iptables\nportPools:\n  default: {first: 1, last: 1023}\nfeatures:\n  conntrackCleanup: false\n",
+		},
+		{
+			name:    "JSON",
+			content: `{"version": "kube-pat/v1", "interfaces": ["eth1"]}`,
+		},
+		{
+			name:    "unknown setting",
+			content: "version: kube-pat/v1\nnatTable: raw\n",
+			wantErr: true,
+		},
+		{
+			name:    "unknown feature",
+			content: "version: kube-pat/v1\nfeatures:\n  ipvs: true\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported backend",
+			content: "version: kube-pat/v1\nbackend: nftables\n",
+			wantErr: true,
+		},
+		{
+			name:    "invalid port pool",
+			content: "version: kube-pat/v1\nportPools:\n  default: {first: 1024, last: 80}\n",
+			wantErr: true,
+		},
+		{
+			name:    "unsupported version",
+			content: "version: kube-pat/v2\n",
+			wantErr: true,
+		},
+	}
+
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, fmt.Sprintf("config-%d.yaml", i))
+			if err := ioutil.WriteFile(path, []byte(tt.content), 0644); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(path); (err != nil) != tt.wantErr {
+				t.Errorf("LoadConfig() error = %v, wantErr %t", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReloadConfigResyncInterval(t *testing.T) {
+	config := &Config{Version: ConfigVersion, ResyncInterval: metav1.Duration{Duration: time.Minute}}
+	c := newTestController(ControllerOptions{Config: config}, newFakeIPTables())
+
+	changed := *config
+	changed.ResyncInterval = metav1.Duration{Duration: time.Hour}
+	if err := c.reloadConfig(&changed); err == nil {
+		t.Errorf("reloadConfig() changing the resync interval succeeded")
+	}
+
+	enabled := true
+	changed = *config
+	changed.Features.MixedProtocol = &enabled
+	if err := c.reloadConfig(&changed); err != nil {
+		t.Fatalf("reloadConfig() error = %v", err)
+	}
+	if !c.options().MixedProtocol {
+		t.Errorf("the reloaded configuration isn't applied")
+	}
+}
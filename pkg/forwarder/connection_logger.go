@@ -0,0 +1,154 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	nflog "github.com/florianl/go-nflog"
+)
+
+// ConnectionLog is a record of a new connection to a PortAddressTranslation.
+type ConnectionLog struct {
+	Timestamp              time.Time `json:"timestamp"`
+	Src                    string    `json:"src"`
+	Dst                    string    `json:"dst"`
+	PortAddressTranslation string    `json:"pat"`
+	Service                string    `json:"service"`
+}
+
+// ConnectionLogger consumes the packets sent to a NFLOG group and writes a
+// JSON ConnectionLog per packet.
+type ConnectionLogger struct {
+	group uint16
+	out   io.Writer
+
+	mu sync.RWMutex
+	// translations are the logged translations per NFLOG prefix, see
+	// nflogPrefix.
+	translations map[string]loggedTranslation
+}
+
+// loggedTranslation is a PortAddressTranslation of an NFLOG prefix and its
+// Service.
+type loggedTranslation struct {
+	pat     string
+	service string
+}
+
+// NewConnectionLogger creates a new ConnectionLogger.
+func NewConnectionLogger(group uint16, out io.Writer) *ConnectionLogger {
+	l := new(ConnectionLogger)
+	l.group = group
+	l.out = out
+	l.translations = map[string]loggedTranslation{}
+	return l
+}
+
+// SetServices sets the name of the Service targeted by each
+// PortAddressTranslation.
+func (l *ConnectionLogger) SetServices(services map[string]string) {
+	translations := map[string]loggedTranslation{}
+	for pat, service := range services {
+		translations[nflogPrefix(pat)] = loggedTranslation{pat, service}
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.translations = translations
+}
+
+// Run consumes the NFLOG group until stopCh is closed.
+func (l *ConnectionLogger) Run(stopCh <-chan struct{}) error {
+	nf, err := nflog.Open(&nflog.Config{
+		Group:    l.group,
+		Copymode: nflog.NfUlnlCopyPacket,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to open NFLOG group %d: %s", l.group, err.Error())
+	}
+	defer nf.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err = nf.Register(ctx, l.handle); err != nil {
+		return fmt.Errorf("failed to consume NFLOG group %d: %s", l.group, err.Error())
+	}
+
+	<-stopCh
+	return nil
+}
+
+func (l *ConnectionLogger) handle(m nflog.Msg) int {
+	// The prefix is derived from the name of the PortAddressTranslation, see
+	// PortForwarder.Log.
+	prefix, _ := m[nflog.AttrPrefix].(string)
+	payload, _ := m[nflog.AttrPayload].([]byte)
+	timestamp, ok := m[nflog.AttrTimestamp].(time.Time)
+	if !ok {
+		timestamp = time.Now()
+	}
+
+	src, dst, err := parseAddresses(payload)
+	if err != nil {
+		return 0
+	}
+
+	l.mu.RLock()
+	translation, ok := l.translations[prefix]
+	l.mu.RUnlock()
+	if !ok {
+		translation.pat = prefix
+	}
+
+	json.NewEncoder(l.out).Encode(ConnectionLog{
+		Timestamp:              timestamp.UTC(),
+		Src:                    src,
+		Dst:                    dst,
+		PortAddressTranslation: translation.pat,
+		Service:                translation.service,
+	})
+	return 0
+}
+
+// parseAddresses returns the source and destination host:port of a TCP or UDP
+// packet over IPv4 or IPv6. IPv6 extension headers are not supported.
+func parseAddresses(packet []byte) (src, dst string, err error) {
+	if len(packet) == 0 {
+		return "", "", errors.New("empty packet")
+	}
+
+	var srcIP, dstIP net.IP
+	var headerLen int
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < 20 {
+			return "", "", errors.New("truncated packet")
+		}
+		srcIP, dstIP = net.IP(packet[12:16]), net.IP(packet[16:20])
+		headerLen = int(packet[0]&0x0f) * 4
+	case 6:
+		if len(packet) < 40 {
+			return "", "", errors.New("truncated packet")
+		}
+		srcIP, dstIP = net.IP(packet[8:24]), net.IP(packet[24:40])
+		headerLen = 40
+	default:
+		return "", "", errors.New("not an IP packet")
+	}
+	if len(packet) < headerLen+4 {
+		return "", "", errors.New("truncated packet")
+	}
+
+	srcPort := binary.BigEndian.Uint16(packet[headerLen : headerLen+2])
+	dstPort := binary.BigEndian.Uint16(packet[headerLen+2 : headerLen+4])
+
+	return net.JoinHostPort(srcIP.String(), fmt.Sprint(srcPort)), net.JoinHostPort(dstIP.String(), fmt.Sprint(dstPort)), nil
+}
@@ -0,0 +1,111 @@
+package forwarder
+
+import (
+	"net"
+	"testing"
+)
+
+// ipv4Packet returns an IPv4 packet with a header of ihl 32-bit words followed
+// by the ports of the transport header.
+func ipv4Packet(ihl int, src, dst string, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, ihl*4+8)
+	packet[0] = 0x40 | byte(ihl)
+	copy(packet[12:16], net.ParseIP(src).To4())
+	copy(packet[16:20], net.ParseIP(dst).To4())
+	putPorts(packet[ihl*4:], srcPort, dstPort)
+	return packet
+}
+
+func ipv6Packet(src, dst string, srcPort, dstPort uint16) []byte {
+	packet := make([]byte, 48)
+	packet[0] = 0x60
+	copy(packet[8:24], net.ParseIP(src).To16())
+	copy(packet[24:40], net.ParseIP(dst).To16())
+	putPorts(packet[40:], srcPort, dstPort)
+	return packet
+}
+
+func putPorts(header []byte, srcPort, dstPort uint16) {
+	header[0], header[1] = byte(srcPort>>8), byte(srcPort)
+	header[2], header[3] = byte(dstPort>>8), byte(dstPort)
+}
+
+func TestParseAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		packet  []byte
+		wantSrc string
+		wantDst string
+		wantErr bool
+	}{
+		{
+			name:    "IPv4",
+			packet:  ipv4Packet(5, "192.0.2.1", "198.51.100.2", 54321, 443),
+			wantSrc: "192.0.2.1:54321",
+			wantDst: "198.51.100.2:443",
+		},
+		{
+			name:    "IPv4 with options",
+			packet:  ipv4Packet(6, "192.0.2.1", "198.51.100.2", 5353, 53),
+			wantSrc: "192.0.2.1:5353",
+			wantDst: "198.51.100.2:53",
+		},
+		{
+			name:    "IPv6",
+			packet:  ipv6Packet("2001:db8::1", "2001:db8::2", 40000, 8080),
+			wantSrc: "[2001:db8::1]:40000",
+			wantDst: "[2001:db8::2]:8080",
+		},
+		{
+			name:    "empty",
+			packet:  nil,
+			wantErr: true,
+		},
+		{
+			name:    "truncated IPv4 header",
+			packet:  ipv4Packet(5, "192.0.2.1", "198.51.100.2", 54321, 443)[:19],
+			wantErr: true,
+		},
+		{
+			name:    "truncated IPv4 ports",
+			packet:  ipv4Packet(5, "192.0.2.1", "198.51.100.2", 54321, 443)[:23],
+			wantErr: true,
+		},
+		{
+			name:    "truncated IPv6 header",
+			packet:  ipv6Packet("2001:db8::1", "2001:db8::2", 40000, 8080)[:39],
+			wantErr: true,
+		},
+		{
+			name:    "not an IP packet",
+			packet:  []byte{0x20, 0, 0, 0},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			src, dst, err := parseAddresses(tt.packet)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseAddresses() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if src != tt.wantSrc || dst != tt.wantDst {
+				t.Errorf("parseAddresses() = %q, %q, want %q, %q", src, dst, tt.wantSrc, tt.wantDst)
+			}
+		})
+	}
+}
+
+func TestNFLOGPrefix(t *testing.T) {
+	long := "namespace-with-a-rather-long-name/translation-with-an-even-longer-name"
+	prefix := nflogPrefix(long)
+	if len(prefix) != len("kp-")+8 {
+		t.Errorf("nflogPrefix(%q) = %q, want a fixed length prefix", long, prefix)
+	}
+	if nflogPrefix(long) != prefix {
+		t.Errorf("nflogPrefix(%q) isn't stable", long)
+	}
+	if nflogPrefix("default/web") == nflogPrefix("default/api") {
+		t.Errorf("nflogPrefix() maps different translations to the same prefix")
+	}
+}
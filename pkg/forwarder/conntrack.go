@@ -0,0 +1,181 @@
+package forwarder
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"syscall"
+	"time"
+
+	"github.com/ti-mo/conntrack"
+	"k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+)
+
+// DeleteConntrack deletes the conntrack entries translated by the rules of a
+// PortForwardingConfig, so that the established flows stop using its
+// destinations. The entries translated to a destination of current, the
+// PortForwardingConfig replacing it, are kept. current is nil when the
+// PortForwardingConfig is removed. It returns the number of entries deleted.
+func (pf PortForwarder) DeleteConntrack(pfc PortForwardingConfig, current *PortForwardingConfig) (int, error) {
+	kept := map[string]bool{}
+	if current != nil && !current.Reject {
+		for _, ip := range current.DestIPs {
+			kept[net.JoinHostPort(ip, fmt.Sprint(current.DestPort))] = true
+		}
+	}
+	externalIPs := map[string]bool{}
+	for _, ip := range pfc.ExternalIPs {
+		externalIPs[net.ParseIP(ip).String()] = true
+	}
+
+	conn, err := conntrack.Dial(nil)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	flows, err := conn.Dump()
+	if err != nil {
+		return 0, err
+	}
+
+	deleted := 0
+	for _, flow := range flows {
+		orig, reply := flow.TupleOrig, flow.TupleReply
+		if !flow.Status.DstNAT() || orig.Proto.Protocol != protocolNumber(pfc.Protocol) || orig.Proto.DestinationPort != uint16(pfc.ListenPort) {
+			continue
+		}
+		if len(externalIPs) > 0 && !externalIPs[orig.IP.DestinationAddress.String()] {
+			continue
+		}
+		// The source of the reply is the destination the flow is translated to.
+		if kept[net.JoinHostPort(reply.IP.SourceAddress.String(), fmt.Sprint(reply.Proto.SourcePort))] {
+			continue
+		}
+		if err = conn.Delete(flow); err != nil {
+			return deleted, err
+		}
+		deleted++
+	}
+	return deleted, nil
+}
+
+func protocolNumber(protocol v1.Protocol) uint8 {
+	if protocol == v1.ProtocolUDP {
+		return syscall.IPPROTO_UDP
+	}
+	return syscall.IPPROTO_TCP
+}
+
+// startDraining keeps the PortForwardingConfigs removed since the previous
+// reconciliation on the load balancers during the grace period, their
+// established flows are deleted once it is over. It must be called before the
+// load balancers are updated.
+func (c Controller) startDraining(previous, desired []PortForwardingConfig) {
+	current := map[string]PortForwardingConfig{}
+	for _, pfc := range desired {
+		current[pfc.PortAddressTranslationName] = pfc
+		delete(c.draining, pfc.PortAddressTranslationName)
+	}
+	if c.options().DrainGracePeriod == 0 {
+		return
+	}
+
+	for _, pfc := range previous {
+		if pfc.Reject {
+			// The rejected traffic is not translated.
+			continue
+		}
+		if next, changed := replacement(pfc, current); changed && next == nil {
+			pfc := pfc
+			c.draining[pfc.PortAddressTranslationName] = pfc
+			time.AfterFunc(c.options().DrainGracePeriod, func() { c.drainConntrack(pfc) })
+		}
+	}
+}
+
+// cleanupConntrack deletes the conntrack entries of the PortForwardingConfigs
+// retargeted since the previous reconciliation, and of the removed ones when
+// they are not drained, see startDraining.
+func (c Controller) cleanupConntrack(previous, desired []PortForwardingConfig) {
+	current := map[string]PortForwardingConfig{}
+	for _, pfc := range desired {
+		current[pfc.PortAddressTranslationName] = pfc
+	}
+
+	for _, pfc := range previous {
+		if pfc.Reject {
+			continue
+		}
+		next, changed := replacement(pfc, current)
+		if !changed || (next == nil && c.options().DrainGracePeriod > 0) {
+			continue
+		}
+		c.deleteConntrack(pfc, next)
+	}
+}
+
+// replacement returns the PortForwardingConfig matching the traffic of a
+// previous one among the current ones, or nil when it is removed, and whether
+// its traffic is translated differently.
+func replacement(pfc PortForwardingConfig, current map[string]PortForwardingConfig) (*PortForwardingConfig, bool) {
+	next, ok := current[pfc.PortAddressTranslationName]
+	if !ok || !sameTraffic(pfc, next) {
+		return nil, true
+	}
+	if !next.Reject && reflect.DeepEqual(pfc.DestIPs, next.DestIPs) && pfc.DestPort == next.DestPort {
+		return &next, false
+	}
+	return &next, true
+}
+
+// drainConntrack deletes the conntrack entries of a removed
+// PortForwardingConfig, once its grace period is over, and withdraws its port
+// from the load balancers. The entries of the PortForwardingConfig matching the
+// same traffic since then are kept.
+func (c Controller) drainConntrack(pfc PortForwardingConfig) {
+	if !c.running.Load().(bool) {
+		return
+	}
+
+	c.drain(pfc)
+	c.Refresh(c.s)
+}
+
+func (c Controller) drain(pfc PortForwardingConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if draining, ok := c.draining[pfc.PortAddressTranslationName]; ok && reflect.DeepEqual(draining, pfc) {
+		delete(c.draining, pfc.PortAddressTranslationName)
+	}
+	var replacement *PortForwardingConfig
+	for _, next := range c.state.lastDesired() {
+		if sameTraffic(pfc, next) {
+			next := next
+			replacement = &next
+			break
+		}
+	}
+	c.deleteConntrack(pfc, replacement)
+}
+
+func (c Controller) deleteConntrack(pfc PortForwardingConfig, replacement *PortForwardingConfig) {
+	if c.options().DisableConntrackCleanup {
+		return
+	}
+	deleted, err := c.pf.DeleteConntrack(pfc, replacement)
+	if err != nil {
+		klog.ErrorS(err, "Failed to delete conntrack entries", "pat", pfc.PortAddressTranslationName, "protocol", pfc.Protocol, "port", pfc.SrcPort)
+		return
+	}
+	if deleted > 0 {
+		klog.InfoS("Deleted conntrack entries", "pat", pfc.PortAddressTranslationName, "protocol", pfc.Protocol, "port", pfc.SrcPort, "count", deleted)
+	}
+}
+
+// sameTraffic returns true when two PortForwardingConfigs match the same
+// incoming traffic.
+func sameTraffic(a, b PortForwardingConfig) bool {
+	return a.Protocol == b.Protocol && a.ListenPort == b.ListenPort && reflect.DeepEqual(a.ExternalIPs, b.ExternalIPs)
+}
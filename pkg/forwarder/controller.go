@@ -1,60 +1,258 @@
 package forwarder
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
-	"reflect"
+	"io"
+	"net"
+	"os"
+	"sort"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/prometheus/client_golang/prometheus"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/client-go/kubernetes"
 
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
 	clientset "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned"
 	informers "github.com/pdeslaur/kube-pat/pkg/client/informers/externalversions/portaddresstranslation/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/dynamic"
 	corev1informers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
 )
 
 // Controller is configuring the port forwarding.
 type Controller struct {
-	opt     ControllerOptions
+	// opt are the ControllerOptions, replaced under mu when the configuration
+	// file is reloaded, see Controller.options.
+	opt     *atomic.Value
 	pf      *PortForwarder
+	cl      *ConnectionLogger
+	dns     *DNSServer
 	s       *Store
 	running *atomic.Value
+	health  *health
+	state   *reconcileState
+
+	// mu serializes the reconciliations.
+	mu     *sync.Mutex
+	events *eventRecorder
+
+	// identity is the name of the replica, its hostname.
+	identity string
+
+	// leader is true while the controller does the cluster-wide writes.
+	leader *atomic.Value
+
+	// mixedProtocol is true while TCP and UDP ports are published on a single
+	// load balancer service.
+	mixedProtocol *atomic.Value
+
+	// base are the options given to the controller, before the configuration
+	// file is applied.
+	base ControllerOptions
+
+	// draining are the removed PortForwardingConfigs whose established flows
+	// are forwarded until their grace period is over, per name. It is guarded
+	// by mu.
+	draining map[string]PortForwardingConfig
+
+	dnsEndpoints *dnsEndpointCache
 }
 
+// DefaultLoadBalancer is the load balancer pool of the translations not
+// specifying one.
+const DefaultLoadBalancer = "default"
+
 // ControllerOptions is a struct for storing configuration options of Controller
 type ControllerOptions struct {
-	LoadBalancersName map[corev1.Protocol]string
-	PatClientSet      *clientset.Clientset
-	KubeClientSet     *kubernetes.Clientset
+	// LoadBalancers are the names of the load balancer services per protocol,
+	// for each load balancer pool.
+	LoadBalancers map[string]map[corev1.Protocol]string
+	// ManagedLoadBalancers are the load balancer services created and owned by
+	// the controller, per namespace/name.
+	ManagedLoadBalancers map[string]LoadBalancerConfig
+	// DedicatedLoadBalancer is the template of the load balancer services
+	// dedicated to a single translation.
+	DedicatedLoadBalancer *LoadBalancerConfig
+	// PortPools are the ports the translations of a load balancer pool may
+	// use, per pool. The translations of a pool without range may use any
+	// port.
+	PortPools map[string]PortRange
+	// MixedProtocol publishes the UDP ports of a pool on its TCP load balancer
+	// service, falling back to one service per protocol when the cluster
+	// doesn't support mixed protocol load balancers.
+	MixedProtocol bool
+	// DisableConnectionLogging ignores the logging of the translations, their
+	// connections are not logged.
+	DisableConnectionLogging bool
+	// DisableConntrackCleanup keeps the established flows of the removed and
+	// retargeted translations, they are not deleted from conntrack.
+	DisableConntrackCleanup bool
+	// DNSZone is the zone of the DNS records published for the translations.
+	// No records are published when empty.
+	DNSZone string
+	// DNSServerAddress is the address of the DNS server answering the queries
+	// for the DNS zone. The server is disabled when empty.
+	DNSServerAddress string
+	// LeaderElectionLease is the namespace/name of the lease electing the
+	// replica updating the load balancers and the translations. Every replica
+	// is the leader when empty.
+	LeaderElectionLease string
+	// ReplicasService is the namespace/name of a service selecting the replicas.
+	// Every ready replica must stop using a deleted translation or load
+	// balancer before its finalizer is removed, a replica which isn't ready
+	// doesn't receive traffic. Only the current replica is waited for when
+	// empty.
+	ReplicasService string
+	// DriftInterval is the interval between the comparisons of the installed
+	// rules with the desired rules. Drifts are not detected when 0.
+	DriftInterval time.Duration
+	// DrainGracePeriod is the time the established flows are forwarded after
+	// the controller is stopped, or after a translation is removed.
+	DrainGracePeriod time.Duration
+	// DryRun computes the forwarding rules and the load balancer changes
+	// without applying them. The translations, their DNS endpoints and
+	// finalizers are not updated, no events are recorded and the replica is
+	// always the leader.
+	DryRun bool
+	// DryRunOutput receives the IPTables rules and the load balancer changes
+	// of each reconciliation in dry run. They are logged when nil.
+	DryRunOutput io.Writer
+	// Interfaces are the network interfaces receiving the translated traffic
+	// and sending the forwarded traffic, DefaultInterfaces when empty.
+	Interfaces []string
+	// Config is the content of the configuration file at ConfigPath, applied
+	// over the other options. The file is reloaded while the controller runs.
+	Config        *Config
+	ConfigPath    string
+	NflogGroup    uint16
+	PatClientSet  clientset.Interface
+	KubeClientSet kubernetes.Interface
+	DynamicClient dynamic.Interface
 }
 
 // NewController creates a new Controller.
 func NewController(
 	opt ControllerOptions,
 	patInformer informers.PortAddressTranslationInformer,
+	clusterPatInformer informers.ClusterPortAddressTranslationInformer,
 	serviceInformer corev1informers.ServiceInformer,
+	endpointsInformer corev1informers.EndpointsInformer,
 ) *Controller {
+	c := newController(opt)
+	if c.options().DryRun {
+		c.pf = NewDryRunPortForwarder(c.options().Interfaces)
+	} else {
+		c.pf = NewPortForwarderOrDie(c.options().Interfaces)
+	}
+
+	prometheus.MustRegister(ruleCollector{c.pf})
+
+	c.s = NewStore(patInformer, clusterPatInformer, serviceInformer, endpointsInformer, c.options().ReplicasService, c.Refresh)
+
+	return c
+}
+
+// newController creates a Controller without its PortForwarder and its Store.
+func newController(opt ControllerOptions) *Controller {
 	c := new(Controller)
-	c.pf = NewPortForwarderOrDie()
-	c.opt = opt
+	identity, err := os.Hostname()
+	if err != nil {
+		panic(err)
+	}
+	c.identity = identity
+	if len(opt.Interfaces) == 0 {
+		opt.Interfaces = DefaultInterfaces
+	}
+	c.base = opt
+	if opt.Config != nil {
+		opt.Config.apply(&opt)
+	}
+	c.cl = NewConnectionLogger(opt.NflogGroup, os.Stdout)
+	if opt.DNSServerAddress != "" {
+		c.dns = NewDNSServer(opt.DNSServerAddress, opt.DNSZone)
+	}
+	c.opt = new(atomic.Value)
+	c.opt.Store(opt)
 	c.running = new(atomic.Value)
 	c.running.Store(false)
-
-	c.s = NewStore(patInformer, serviceInformer, c.Refresh)
-
+	c.health = newHealth()
+	c.state = new(reconcileState)
+	c.mu = new(sync.Mutex)
+	c.draining = map[string]PortForwardingConfig{}
+	c.dnsEndpoints = new(dnsEndpointCache)
+	c.events = newEventRecorder(opt.KubeClientSet)
+	c.leader = new(atomic.Value)
+	c.leader.Store(opt.LeaderElectionLease == "" || opt.DryRun)
+	c.mixedProtocol = new(atomic.Value)
+	c.mixedProtocol.Store(opt.MixedProtocol)
 	return c
 }
 
+// options returns the current options of the controller.
+func (c Controller) options() ControllerOptions {
+	return c.opt.Load().(ControllerOptions)
+}
+
 // Run starts the controller
 func (c Controller) Run(stopCh <-chan struct{}) {
+	if !c.options().DryRun {
+		go func() {
+			if err := c.cl.Run(stopCh); err != nil {
+				klog.ErrorS(err, "Connection logging is disabled", "group", c.options().NflogGroup)
+			}
+		}()
+	}
+	if c.dns != nil {
+		go func() {
+			if err := c.dns.Run(stopCh); err != nil {
+				klog.ErrorS(err, "DNS server stopped", "address", c.options().DNSServerAddress)
+			}
+		}()
+	}
+
 	c.running.Store(true)
 	c.Refresh(c.s)
 
+	if c.options().LeaderElectionLease != "" && !c.options().DryRun {
+		go c.runLeaderElection(stopCh)
+	}
+	if c.options().DriftInterval > 0 && !c.options().DryRun {
+		go c.runDriftDetection(c.options().DriftInterval, stopCh)
+	}
+	if c.base.ConfigPath != "" {
+		go c.runConfigReload(stopCh)
+	}
+
 	<-stopCh
 
+	// The load balancers stop sending new connections to the replica once it
+	// is not ready, the established flows are forwarded during the grace
+	// period.
+	c.health.draining.Store(true)
+	gracePeriod := c.options().DrainGracePeriod
+	klog.InfoS("Draining", "gracePeriod", gracePeriod)
+	time.Sleep(gracePeriod)
+
 	c.running.Store(false)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	klog.InfoS("Removing the forwarding rules")
+	if err := c.pf.Teardown(); err != nil {
+		klog.ErrorS(err, "Failed to remove the forwarding rules")
+	}
 }
 
 // Refresh updates the Controller configuration
@@ -63,82 +261,596 @@ func (c Controller) Refresh(s *Store) error {
 		return nil
 	}
 
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	start := time.Now()
+	previous := c.state.lastDesired()
+	pfcs, failures, err := c.reconcile(s)
+	if c.options().DryRun {
+		c.reportScripts()
+	} else if pfcs != nil || err == nil {
+		// The established flows keep their translation until deleted.
+		c.cleanupConntrack(previous, pfcs)
+	}
+	c.state.set(pfcs, failures, err)
+	reconcileDuration.Observe(time.Since(start).Seconds())
+	reconcilesTotal.WithLabelValues(result(err)).Inc()
+	c.health.record(err)
+	if err != nil {
+		klog.ErrorS(err, "Failed to reconcile")
+	}
+	return err
+}
+
+// reconcile configures the forwarding rules and the cluster-wide state. It
+// returns the configured PortForwardingConfigs and the errors of the
+// translations skipped or failed, and an error when the node failed to be
+// configured. A translation failing to be programmed doesn't fail the
+// reconciliation, its rules are rolled back.
+func (c Controller) reconcile(s *Store) ([]PortForwardingConfig, map[string]string, error) {
+	// The bound translations are checked against the local addresses.
+	var local map[string]bool
+	if !c.options().DryRun {
+		var err error
+		if local, err = localAddresses(); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	// Clears the current configuration
 	err := c.pf.Clear()
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	// The translations skipped by the store are counted by its goroutine.
+	skipped, skippedByStore := map[string]float64{}, map[string]float64{}
+	failures, failuresByStore := map[string]string{}, map[string]string{}
+	var pfcs, dedicated, all []PortForwardingConfig
+	onSkipped := func(obj runtime.Object, err error) {
+		skippedByStore[skipReason(err)]++
+		failuresByStore[objectName(obj)] = err.Error()
+		c.skipEvent(obj, err)
+	}
+	for pfc := range s.iterate(onSkipped) {
+		all = append(all, pfc)
+		if pfc.DedicatedLoadBalancer {
+			pfc.ListenPort, err = c.dedicatedTargetPort(pfc, s)
+		} else if _, ok := c.options().LoadBalancers[pfc.LoadBalancer]; !ok {
+			err = skip(skipUnknownLoadBalancer, "unknown load balancer %s for %s", pfc.LoadBalancer, pfc.PortAddressTranslationName)
+		} else if ports, ok := c.options().PortPools[pfc.LoadBalancer]; ok && !ports.contains(pfc.SrcPort) {
+			err = skip(skipPortOutOfPool, "port %d of %s is out of the ports %d-%d of load balancer pool %s", pfc.SrcPort, pfc.PortAddressTranslationName, ports.First, ports.Last, pfc.LoadBalancer)
+		} else if local != nil {
+			err = checkBoundAddresses(pfc, local)
+		}
+		if err != nil {
+			if skipReason(err) == skipPendingLoadBalancer {
+				// The dedicated load balancer is created before its
+				// translation is configured.
+				dedicated = append(dedicated, pfc)
+			}
+			klog.ErrorS(err, "Skipping translation", "pat", pfc.PortAddressTranslationName, "reason", skipReason(err))
+			skipped[skipReason(err)]++
+			failures[pfc.PortAddressTranslationName] = err.Error()
+			c.skipEvent(translation(pfc, s), err)
+			err = nil
+			continue
+		}
+		pfcs = append(pfcs, pfc)
 	}
 
-	for pfc := range s.Iterate() {
-		fmt.Printf("Configuring %s\n", pfc.PortAddressTranslationName)
-		err = c.pf.Forward(pfc.Protocol, pfc.SrcPort, pfc.DestIP, pfc.DestPort)
+	// The rules matching external IPs must come first, see PortForwarder.Forward.
+	sort.SliceStable(pfcs, func(i, j int) bool {
+		return len(pfcs[i].ExternalIPs) > 0 && len(pfcs[j].ExternalIPs) == 0
+	})
+
+	failed := 0
+	var configured []PortForwardingConfig
+	programmed := map[corev1.Protocol]float64{}
+	loggedServices := map[string]string{}
+	for _, pfc := range pfcs {
+		klog.V(2).InfoS("Configuring translation", "pat", pfc.PortAddressTranslationName, "protocol", pfc.Protocol, "port", pfc.SrcPort, "service", pfc.ServiceName)
+		checkpoint := c.pf.Checkpoint()
+		if pfc.Reject {
+			err = c.pf.Reject(pfc)
+		} else {
+			err = c.pf.Forward(pfc)
+		}
+		if err == nil && pfc.Limits != nil {
+			err = c.pf.Limit(pfc)
+		}
+		if err == nil && pfc.Logging && !c.options().DisableConnectionLogging {
+			err = c.pf.Log(pfc, c.options().NflogGroup)
+			loggedServices[pfc.PortAddressTranslationName] = pfc.ServiceName
+		}
+		if err == nil {
+			err = c.pf.Account(pfc)
+		}
+		if err != nil {
+			// A translation without its limits or its rejection must not
+			// forward any traffic.
+			if rollbackErr := c.pf.Rollback(checkpoint); rollbackErr != nil {
+				klog.ErrorS(rollbackErr, "Failed to roll back translation", "pat", pfc.PortAddressTranslationName)
+				failed++
+			}
+			delete(loggedServices, pfc.PortAddressTranslationName)
+		}
+		if _, ok := err.(skipError); ok {
+			klog.ErrorS(err, "Skipping translation", "pat", pfc.PortAddressTranslationName, "reason", skipReason(err))
+			skipped[skipReason(err)]++
+			failures[pfc.PortAddressTranslationName] = err.Error()
+			c.skipEvent(translation(pfc, s), err)
+			continue
+		}
 		if err != nil {
-			fmt.Printf("Failed to setup forwarding: %s\n", err.Error())
+			// A translation the node fails to program, like one using a
+			// match its kernel lacks, is skipped without affecting the
+			// readiness of the node.
+			klog.ErrorS(err, "Failed to setup forwarding", "pat", pfc.PortAddressTranslationName, "protocol", pfc.Protocol, "port", pfc.SrcPort, "service", pfc.ServiceName)
+			skipped[skipProgrammingFailed]++
+			failures[pfc.PortAddressTranslationName] = err.Error()
+			c.event(translation(pfc, s), corev1.EventTypeWarning, reasonFailed, err.Error())
+			continue
+		}
+		configured = append(configured, pfc)
+		programmed[pfc.Protocol]++
+		if pfc.Reject {
+			c.event(translation(pfc, s), corev1.EventTypeNormal, reasonProgrammed, fmt.Sprintf("Rejecting %s port %d", pfc.Protocol, pfc.SrcPort))
+		} else {
+			c.event(translation(pfc, s), corev1.EventTypeNormal, reasonProgrammed, fmt.Sprintf("Forwarding %s port %d to service %s", pfc.Protocol, pfc.SrcPort, pfc.ServiceName))
+		}
+	}
+	c.cl.SetServices(loggedServices)
+	c.forgetDeletedEvents(s)
+	// The counters of a translation failing for a while keep their value.
+	desired := map[string]bool{}
+	for _, pfc := range pfcs {
+		desired[pfc.PortAddressTranslationName] = true
+	}
+	c.pf.ForgetCounters(desired)
+	pfcs = configured
+	if !c.options().DryRun {
+		c.startDraining(c.state.lastDesired(), pfcs)
+	}
+	// The load balancers publish the ports of the configured translations.
+	for _, pfc := range pfcs {
+		if pfc.DedicatedLoadBalancer {
+			dedicated = append(dedicated, pfc)
 		}
 	}
+	known := map[string]bool{}
+	for _, pfc := range all {
+		known[loadBalancerPort(pfc).Name] = true
+	}
+	for _, pfc := range c.draining {
+		known[loadBalancerPort(pfc).Name] = true
+	}
+	for reason, count := range skippedByStore {
+		skipped[reason] += count
+	}
+	for name, failure := range failuresByStore {
+		failures[name] = failure
+	}
+	skippedTranslations.Reset()
+	for reason, count := range skipped {
+		skippedTranslations.WithLabelValues(reason).Set(count)
+	}
+	programmedTranslations.Reset()
+	for protocol, count := range programmed {
+		programmedTranslations.WithLabelValues(string(protocol)).Set(count)
+	}
 
-	c.UpdateLoadBalancer(corev1.ProtocolTCP, s)
-	c.UpdateLoadBalancer(corev1.ProtocolUDP, s)
+	addresses := map[string][]string{}
+	for _, pfc := range pfcs {
+		addresses[pfc.PortAddressTranslationName] = c.externalAddresses(pfc, s)
+	}
+
+	objects, err := c.finalizables(s)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list the finalized objects")
+	}
+	if !c.options().DryRun {
+		c.reportCleanups(objects)
+	}
+
+	// Only the leader writes the cluster-wide state, the other replicas only
+	// configure their node.
+	if c.leader.Load().(bool) {
+		withdrawn := true
+		for pool := range c.options().LoadBalancers {
+			withdrawn = c.updateLoadBalancers(pool, pfcs, known) && withdrawn
+		}
+		withdrawn = c.updateDedicatedLoadBalancers(dedicated, all, s) && withdrawn
+		if !c.options().DryRun {
+			c.publishEndpoints(pfcs, addresses, s)
+			c.updateFinalizers(objects, s, withdrawn)
+		}
+	} else {
+		// The leader writes the DNSEndpoints meanwhile.
+		c.dnsEndpoints.objects = nil
+	}
+	if c.dns != nil {
+		c.dns.SetRecords(pfcs, addresses)
+	}
 
 	c.pf.Print()
 
-	return nil
+	if failed > 0 {
+		return pfcs, failures, fmt.Errorf("failed to roll back the rules of %d translations", failed)
+	}
+	return pfcs, failures, nil
 }
 
-// UpdateLoadBalancer add ports to the load balancer
-func (c Controller) UpdateLoadBalancer(protocol corev1.Protocol, s *Store) error {
-	lbName := c.opt.LoadBalancersName[protocol]
-	if lbName == "" {
-		// The given protocol is not configured
-		return nil
+// updateLoadBalancers updates the load balancer services of a pool with the
+// ports of the configured PortForwardingConfigs. known are the names of the
+// ports of all the translations, see ownedPorts. It returns false when a load
+// balancer failed to update.
+func (c Controller) updateLoadBalancers(pool string, pfcs []PortForwardingConfig, known map[string]bool) bool {
+	tcpName := c.options().LoadBalancers[pool][corev1.ProtocolTCP]
+	udpName := c.options().LoadBalancers[pool][corev1.ProtocolUDP]
+
+	if c.mixedProtocol.Load().(bool) && tcpName != "" {
+		err := c.UpdateLoadBalancer(pool, tcpName, []corev1.Protocol{corev1.ProtocolTCP, corev1.ProtocolUDP}, pfcs, known)
+		if err == nil && udpName != "" && udpName != tcpName {
+			// Withdraws the ports from the UDP load balancer.
+			err = c.UpdateLoadBalancer(pool, udpName, nil, pfcs, known)
+		}
+		if !isMixedProtocolRejection(err) {
+			if err != nil {
+				klog.ErrorS(err, "Failed to update load balancer", "pool", pool)
+			}
+			return err == nil
+		}
+		klog.InfoS("Mixed protocol load balancers are rejected, using one load balancer per protocol", "pool", pool, "err", err)
+		c.mixedProtocol.Store(false)
+	}
+
+	updated := true
+	for _, protocol := range []corev1.Protocol{corev1.ProtocolTCP, corev1.ProtocolUDP} {
+		lbName := c.options().LoadBalancers[pool][protocol]
+		if lbName == "" {
+			// The given protocol is not configured
+			continue
+		}
+		if err := c.UpdateLoadBalancer(pool, lbName, []corev1.Protocol{protocol}, pfcs, known); err != nil {
+			klog.ErrorS(err, "Failed to update load balancer", "pool", pool, "protocol", protocol)
+			updated = false
+		}
+	}
+	return updated
+}
+
+// UpdateLoadBalancer add the ports of the PortForwardingConfigs of a pool to a
+// load balancer, for the given protocols. known are the names of the ports of
+// all the translations, see ownedPorts.
+func (c Controller) UpdateLoadBalancer(pool, lbName string, protocols []corev1.Protocol, pfcs []PortForwardingConfig, known map[string]bool) error {
+	published := map[corev1.Protocol]bool{}
+	for _, protocol := range protocols {
+		published[protocol] = true
+	}
+
+	var ports []corev1.ServicePort
+	for _, pfc := range pfcs {
+		if !published[pfc.Protocol] || pfc.LoadBalancer != pool {
+			continue
+		}
+		ports = append(ports, loadBalancerPort(pfc))
+	}
+	// The load balancers keep sending the established flows of a removed
+	// translation until its grace period is over.
+	for _, pfc := range c.draining {
+		if published[pfc.Protocol] && pfc.LoadBalancer == pool {
+			ports = append(ports, loadBalancerPort(pfc))
+		}
+	}
+
+	// Translations bound to different addresses share a port of the load
+	// balancer, named after the first translation.
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+	var desired []corev1.ServicePort
+	seen := map[string]bool{}
+	for _, port := range ports {
+		if !seen[portKey(port)] {
+			seen[portKey(port)] = true
+			desired = append(desired, port)
+		}
+	}
+
+	var config *LoadBalancerConfig
+	if managed, ok := c.options().ManagedLoadBalancers[lbName]; ok {
+		config = &managed
 	}
+	return c.applyLoadBalancer(lbName, desired, known, config)
+}
+
+// isMixedProtocolRejection returns whether an error is the rejection of a load
+// balancer service with ports of several protocols, by an API server without
+// the MixedProtocolLBService feature.
+func isMixedProtocolRejection(err error) bool {
+	status, ok := err.(errors.APIStatus)
+	if !ok || !errors.IsInvalid(err) || status.Status().Details == nil {
+		return false
+	}
+	for _, cause := range status.Status().Details.Causes {
+		if !strings.HasPrefix(cause.Field, "spec.ports") {
+			continue
+		}
+		if strings.Contains(cause.Message, "mix protocols") || strings.Contains(cause.Message, "more than 1 protocol") {
+			return true
+		}
+	}
+	return false
+}
 
-	lbNamespace, lbName := split(lbName)
-	lbService, err := c.opt.KubeClientSet.CoreV1().Services(lbNamespace).Get(lbName, metav1.GetOptions{})
+// dedicatedTargetPort returns the target port of the load balancer dedicated
+// to a PortForwardingConfig. Kubernetes translates the destination of the
+// traffic sent to a load balancer to the IP of a forwarder, the target port is
+// the only thing telling the dedicated load balancers apart.
+func (c Controller) dedicatedTargetPort(pfc PortForwardingConfig, s *Store) (int32, error) {
+	if c.options().DedicatedLoadBalancer == nil {
+		return 0, skip(skipInvalidSpec, "dedicated load balancers are not configured, required by %s", pfc.PortAddressTranslationName)
+	}
+	config, err := dedicatedLoadBalancerConfig(*c.options().DedicatedLoadBalancer, pfc)
 	if err != nil {
-		return fmt.Errorf("Failed to fetch the LoadBalancer service for protocol %s", protocol)
+		return 0, err
+	}
+	namespace, name, err := splitName(config.Name)
+	if err != nil {
+		return 0, err
+	}
+	service, err := s.GetService(namespace, name)
+	if err != nil || service.DeletionTimestamp != nil {
+		return 0, skip(skipPendingLoadBalancer, "waiting for load balancer %s of %s", config.Name, pfc.PortAddressTranslationName)
+	}
+	if service.Annotations[portaddresstranslation.TranslationAnnotationKey] != pfc.PortAddressTranslationName {
+		return 0, skip(skipInvalidSpec, "load balancer %s of %s belongs to another translation", config.Name, pfc.PortAddressTranslationName)
+	}
+	targetPort := dedicatedTargetPort(service, pfc)
+	if targetPort == 0 {
+		return 0, skip(skipPendingLoadBalancer, "waiting for the target port of load balancer %s of %s", config.Name, pfc.PortAddressTranslationName)
+	}
+	return targetPort, nil
+}
+
+// updateDedicatedLoadBalancers updates the load balancer services dedicated to
+// the given PortForwardingConfigs, and deletes the ones no longer needed. The
+// target ports are allocated apart from the ports of all the
+// PortForwardingConfigs. It returns false when a load balancer failed to update
+// or to be deleted.
+func (c Controller) updateDedicatedLoadBalancers(dedicated, all []PortForwardingConfig, s *Store) bool {
+	if c.options().DedicatedLoadBalancer == nil {
+		return true
+	}
+
+	namespace, _, err := splitName(c.options().DedicatedLoadBalancer.Name)
+	if err != nil {
+		klog.ErrorS(err, "Invalid dedicated load balancers")
+		return false
 	}
 
-	lbPorts := map[int32]bool{}
-	for _, port := range lbService.Spec.Ports {
-		lbPorts[port.Port] = true
+	// The load balancers of the removed translations are kept until their
+	// grace period is over.
+	names := map[string]bool{}
+	for _, pfc := range dedicated {
+		names[pfc.PortAddressTranslationName] = true
+	}
+	for name, pfc := range c.draining {
+		if pfc.DedicatedLoadBalancer && !names[name] {
+			dedicated = append(dedicated, pfc)
+		}
+	}
+	services, err := s.Services(namespace, labels.SelectorFromSet(labels.Set{portaddresstranslation.DedicatedLoadBalancerLabelKey: "true"}))
+	if err != nil {
+		klog.ErrorS(err, "Failed to list dedicated load balancers")
+		return false
 	}
 
-	requiredPorts := map[int32]bool{}
-	var servicePorts []corev1.ServicePort
+	// The target ports are allocated once, when the load balancer is created.
+	// They must differ from the ports of the other translations.
+	used := map[string]bool{}
+	for _, pfc := range all {
+		if !pfc.DedicatedLoadBalancer {
+			used[fmt.Sprintf("%s:%d", pfc.Protocol, pfc.ListenPort)] = true
+		}
+	}
+	for _, service := range services {
+		for _, port := range service.Spec.Ports {
+			used[fmt.Sprintf("%s:%d", port.Protocol, port.TargetPort.IntVal)] = true
+		}
+	}
+
+	updated := true
+	desired := map[string]bool{}
+	for _, pfc := range dedicated {
+		var name string
+		config, err := dedicatedLoadBalancerConfig(*c.options().DedicatedLoadBalancer, pfc)
+		if err == nil {
+			_, name, err = splitName(config.Name)
+		}
+		if err != nil {
+			klog.ErrorS(err, "Invalid dedicated load balancer", "pat", pfc.PortAddressTranslationName)
+			updated = false
+			continue
+		}
+		desired[config.Name] = true
+
+		var targetPort int32
+		if service, err := s.GetService(namespace, name); err == nil && service.Annotations[portaddresstranslation.TranslationAnnotationKey] == pfc.PortAddressTranslationName {
+			targetPort = dedicatedTargetPort(service, pfc)
+		}
+		if targetPort == 0 {
+			if targetPort, err = allocateTargetPort(c.options().DedicatedLoadBalancer.targetPorts(), pfc.Protocol, used); err != nil {
+				klog.ErrorS(err, "Failed to allocate a target port", "service", config.Name, "pat", pfc.PortAddressTranslationName)
+				updated = false
+				continue
+			}
+		}
+
+		port := loadBalancerPort(pfc)
+		port.TargetPort = intstr.FromInt(int(targetPort))
+		if err := c.applyLoadBalancer(config.Name, []corev1.ServicePort{port}, map[string]bool{port.Name: true}, &config); err != nil {
+			klog.ErrorS(err, "Failed to update load balancer", "service", config.Name, "pat", pfc.PortAddressTranslationName)
+			updated = false
+		}
+	}
 
-	for pfc := range s.Iterate() {
-		if pfc.Protocol != protocol {
+	for _, service := range services {
+		if desired[fmt.Sprintf("%s/%s", service.Namespace, service.Name)] || service.DeletionTimestamp != nil {
+			continue
+		}
+		if c.options().DryRun {
+			c.reportDryRun("delete", fmt.Sprintf("service %s/%s", service.Namespace, service.Name), "")
 			continue
 		}
-		requiredPorts[pfc.SrcPort] = true
+		klog.InfoS("Deleting load balancer", "service", klog.KObj(service))
+		err := c.options().KubeClientSet.CoreV1().Services(service.Namespace).Delete(context.TODO(), service.Name, metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to delete load balancer", "service", klog.KObj(service))
+			updated = false
+		}
+	}
+	return updated
+}
 
-		servicePorts = append(servicePorts, corev1.ServicePort{
-			Name:     fmt.Sprintf("%s-%d", strings.Replace(pfc.PortAddressTranslationName, "/", "-", -1), pfc.SrcPort),
-			Protocol: protocol,
-			Port:     pfc.SrcPort,
-		})
+// applyLoadBalancer updates the ports managed by the controller on a load
+// balancer. A load balancer with a config is owned by the controller, it is
+// created when missing and its fields are reconciled. known are the names of
+// the ports of the known translations, see ownedPorts.
+func (c Controller) applyLoadBalancer(lbName string, desired []corev1.ServicePort, known map[string]bool, config *LoadBalancerConfig) error {
+	lbNamespace, lbName, err := splitName(lbName)
+	if err != nil {
+		return err
 	}
+	services := c.options().KubeClientSet.CoreV1().Services(lbNamespace)
+	err = retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		lbService, err := services.Get(context.TODO(), lbName, metav1.GetOptions{})
+		if errors.IsNotFound(err) && config != nil {
+			return c.createLoadBalancer(*config, desired)
+		}
+		if err != nil {
+			return fmt.Errorf("Failed to fetch the LoadBalancer service %s/%s: %s", lbNamespace, lbName, err.Error())
+		}
+		if config != nil {
+			// A dedicated load balancer is only adopted by its translation.
+			if owner := config.Annotations[portaddresstranslation.TranslationAnnotationKey]; owner != "" && lbService.Annotations[portaddresstranslation.TranslationAnnotationKey] != owner {
+				return fmt.Errorf("service %s/%s doesn't belong to translation %s", lbNamespace, lbName, owner)
+			}
+		}
+		if lbService.DeletionTimestamp != nil {
+			// The service is created again once deleted.
+			return nil
+		}
+		if ports, _, changed := mergePorts(lbService, desired, known); changed && len(ports) == 0 {
+			// Kubernetes doesn't allow an empty list of ports in services.
+			return c.deleteLoadBalancer(lbService, config)
+		}
+
+		patch, err := loadBalancerPatch(lbService, desired, known, config)
+		if err != nil || patch == nil {
+			return err
+		}
+		if c.options().DryRun {
+			c.reportDryRun("patch", fmt.Sprintf("service %s/%s", lbNamespace, lbName), string(patch))
+			return nil
+		}
+
+		klog.InfoS("Updating load balancer", "service", klog.KRef(lbNamespace, lbName))
+		_, err = services.Patch(context.TODO(), lbName, types.MergePatchType, patch, metav1.PatchOptions{})
+		return err
+	})
+	loadBalancerUpdatesTotal.WithLabelValues(fmt.Sprintf("%s/%s", lbNamespace, lbName), result(err)).Inc()
+	return err
+}
 
-	if len(requiredPorts) == 0 {
-		// Never update the load balancers if no ports are needed. Kubernetes
-		// doesn't allow an empty list of ports in services.
+// deleteLoadBalancer withdraws the last ports of a load balancer service by
+// deleting it, when it is owned by the controller and configured to be deleted
+// when empty.
+func (c Controller) deleteLoadBalancer(lbService *corev1.Service, config *LoadBalancerConfig) error {
+	if config == nil {
+		return fmt.Errorf("can't withdraw the last ports of service %s/%s, a service requires a port", lbService.Namespace, lbService.Name)
+	}
+	if !config.DeleteWhenEmpty {
+		return fmt.Errorf("can't withdraw the last ports of service %s/%s, a service requires a port: configure static ports or deleteWhenEmpty", lbService.Namespace, lbService.Name)
+	}
+	if c.options().DryRun {
+		c.reportDryRun("delete", fmt.Sprintf("service %s/%s", lbService.Namespace, lbService.Name), "")
+		return nil
+	}
+	klog.InfoS("Deleting load balancer without ports", "service", klog.KObj(lbService))
+	err := c.options().KubeClientSet.CoreV1().Services(lbService.Namespace).Delete(context.TODO(), lbService.Name, metav1.DeleteOptions{})
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+// createLoadBalancer creates a load balancer service owned by the controller.
+func (c Controller) createLoadBalancer(config LoadBalancerConfig, desired []corev1.ServicePort) error {
+	lbService, err := newLoadBalancerService(config)
+	if err != nil {
+		return err
+	}
+	// The ports of the config are static.
+	lbService.Annotations[portaddresstranslation.OwnedPortsAnnotationKey] = ""
+	ports, owned, _ := mergePorts(lbService, desired, nil)
+	if len(ports) == 0 {
+		// Kubernetes doesn't allow an empty list of ports in services.
 		return nil
 	}
+	lbService.Spec.Ports = ports
+	lbService.Annotations[portaddresstranslation.OwnedPortsAnnotationKey] = strings.Join(owned, ",")
+	lbService.Finalizers = []string{portaddresstranslation.Finalizer}
 
-	if !reflect.DeepEqual(requiredPorts, lbPorts) {
-		lbService.Spec.Ports = servicePorts
-		fmt.Printf("Updating %s load balancer\n", protocol)
-		_, err = c.opt.KubeClientSet.CoreV1().Services(lbNamespace).Update(lbService)
+	if c.options().DryRun {
+		manifest, err := json.Marshal(lbService)
 		if err != nil {
 			return err
 		}
+		c.reportDryRun("create", "service "+config.Name, string(manifest))
+		return nil
 	}
 
+	klog.InfoS("Creating load balancer", "service", config.Name)
+	_, err = c.options().KubeClientSet.CoreV1().Services(lbService.Namespace).Create(context.TODO(), lbService, metav1.CreateOptions{})
+	return err
+}
+
+// localAddresses returns the IPs assigned to the network interfaces of the
+// forwarder.
+func localAddresses() (map[string]bool, error) {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("Failed to list the local addresses: %s", err.Error())
+	}
+	local := map[string]bool{}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok {
+			local[ipNet.IP.String()] = true
+		}
+	}
+	return local, nil
+}
+
+// checkBoundAddresses returns an error when a PortForwardingConfig is bound to
+// an address which is not assigned to the forwarder. The traffic sent to a
+// load balancer service reaches the forwarder with its pod IP as destination,
+// the traffic is only sent to the bound address when the address is routed to
+// the forwarder directly, like a virtual IP of a forwarder on the host network.
+func checkBoundAddresses(pfc PortForwardingConfig, local map[string]bool) error {
+	for _, ip := range pfc.ExternalIPs {
+		if !local[net.ParseIP(ip).String()] {
+			return skip(skipNonLocalAddress, "address %s of %s is not assigned to the forwarder, it never receives traffic sent to it", ip, pfc.PortAddressTranslationName)
+		}
+	}
 	return nil
 }
 
-func split(s string) (namespace, name string) {
+// splitName returns the namespace and the name of a namespace/name.
+func splitName(s string) (namespace, name string, err error) {
 	parts := strings.SplitN(s, "/", 2)
-	return parts[0], parts[1]
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("%q is not a namespace/name", s)
+	}
+	return parts[0], parts[1], nil
 }
@@ -0,0 +1,302 @@
+package forwarder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/coreos/go-iptables/iptables"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	corev1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+	patfake "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned/fake"
+	listers "github.com/pdeslaur/kube-pat/pkg/client/listers/portaddresstranslation/v1beta1"
+)
+
+// newTestStore returns a Store listing the given translations, services and
+// endpoints.
+func newTestStore(objects ...runtime.Object) *Store {
+	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
+	pats := cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+	cpats := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{})
+	services := cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+	endpoints := cache.NewIndexer(cache.MetaNamespaceKeyFunc, indexers)
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *patv1beta1.PortAddressTranslation:
+			pats.Add(obj)
+		case *patv1beta1.ClusterPortAddressTranslation:
+			cpats.Add(obj)
+		case *corev1.Service:
+			services.Add(obj)
+		case *corev1.Endpoints:
+			endpoints.Add(obj)
+		}
+	}
+	return &Store{
+		patLister:        listers.NewPortAddressTranslationLister(pats),
+		clusterPatLister: listers.NewClusterPortAddressTranslationLister(cpats),
+		serviceLister:    corev1listers.NewServiceLister(services),
+		endpointsLister:  corev1listers.NewEndpointsLister(endpoints),
+	}
+}
+
+// newTestController returns a running Controller programming the rules with
+// fake IPTables and writing to fake clientsets holding the given objects. Its
+// Store lists the same objects.
+func newTestController(opt ControllerOptions, ipt *fakeIPTables, objects ...runtime.Object) *Controller {
+	var kubeObjects, patObjects []runtime.Object
+	for _, obj := range objects {
+		switch obj.(type) {
+		case *patv1beta1.PortAddressTranslation, *patv1beta1.ClusterPortAddressTranslation:
+			patObjects = append(patObjects, obj)
+		default:
+			kubeObjects = append(kubeObjects, obj)
+		}
+	}
+	opt.KubeClientSet = kubefake.NewSimpleClientset(kubeObjects...)
+	opt.PatClientSet = patfake.NewSimpleClientset(patObjects...)
+
+	c := newController(opt)
+	c.pf = newFakePortForwarder(map[iptables.Protocol]iptablesInterface{iptables.ProtocolIPv4: ipt})
+	c.s = newTestStore(objects...)
+	c.running.Store(true)
+	return c
+}
+
+func testService(namespace, name string, protocol corev1.Protocol, port int32) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Spec: corev1.ServiceSpec{
+			Type:      corev1.ServiceTypeClusterIP,
+			ClusterIP: "10.0.0.1",
+			Ports:     []corev1.ServicePort{{Protocol: protocol, Port: port}},
+		},
+	}
+}
+
+func testTranslation(namespace, name string, spec patv1beta1.PortAddressTranslationSpec) *patv1beta1.PortAddressTranslation {
+	return &patv1beta1.PortAddressTranslation{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, UID: "uid-" + name},
+		Spec:       spec,
+	}
+}
+
+// names returns the names of PortForwardingConfigs.
+func names(pfcs []PortForwardingConfig) []string {
+	var names []string
+	for _, pfc := range pfcs {
+		names = append(names, pfc.PortAddressTranslationName)
+	}
+	return names
+}
+
+func TestRefreshProgrammingFailure(t *testing.T) {
+	// The node lacks the hashlimit match.
+	ipt := newFakeIPTables("hashlimit")
+	opt := ControllerOptions{LoadBalancers: map[string]map[corev1.Protocol]string{DefaultLoadBalancer: {corev1.ProtocolTCP: "kube-pat/lb"}}}
+	c := newTestController(opt, ipt,
+		testService("default", "web", corev1.ProtocolTCP, 8080),
+		testTranslation("default", "limited", patv1beta1.PortAddressTranslationSpec{
+			Service: "web", Port: 443, Limits: &patv1beta1.Limits{PacketsPerSecond: 100},
+		}),
+		testTranslation("default", "plain", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 80}),
+	)
+	c.leader.Store(false)
+
+	for i := 0; i < maxReconcileFailures; i++ {
+		if err := c.Refresh(c.s); err != nil {
+			t.Fatalf("Refresh() error = %v", err)
+		}
+	}
+	if err := c.Ready(); err != nil {
+		t.Errorf("Ready() error = %v", err)
+	}
+
+	if got, want := names(c.state.lastDesired()), []string{"default/plain"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("configured translations = %q, want %q", got, want)
+	}
+	if _, ok := c.state.failures["default/limited"]; !ok {
+		t.Errorf("failures = %v, want the failure of default/limited", c.state.failures)
+	}
+	want := []string{"-p TCP --dport 80 -j DNAT --to-destination 10.0.0.1:8080"}
+	if got := ipt.owned("nat"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nat rules = %q, want %q", got, want)
+	}
+}
+
+func testLoadBalancer(namespace, name string, ports ...corev1.ServicePort) *corev1.Service {
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Annotations: map[string]string{portaddresstranslation.OwnedPortsAnnotationKey: ""},
+		},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports},
+	}
+}
+
+func TestRefreshLoadBalancerPorts(t *testing.T) {
+	ipt := newFakeIPTables("hashlimit")
+	opt := ControllerOptions{
+		LoadBalancers:         map[string]map[corev1.Protocol]string{DefaultLoadBalancer: {corev1.ProtocolTCP: "kube-pat/lb"}},
+		DedicatedLoadBalancer: &LoadBalancerConfig{Name: "kube-pat/dedicated", Selector: map[string]string{"app": "kube-pat"}},
+	}
+	c := newTestController(opt, ipt,
+		testService("default", "web", corev1.ProtocolTCP, 8080),
+		testLoadBalancer("kube-pat", "lb", corev1.ServicePort{Name: "ssh", Protocol: corev1.ProtocolTCP, Port: 22}),
+		testTranslation("default", "configured", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443}),
+		testTranslation("default", "conflicting", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443}),
+		testTranslation("default", "failing", patv1beta1.PortAddressTranslationSpec{
+			Service: "web", Port: 8443, Limits: &patv1beta1.Limits{PacketsPerSecond: 100},
+		}),
+		// The forwarder never receives the traffic of an address it doesn't
+		// have, its port is left out of the load balancers.
+		testTranslation("default", "unbound", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 40000, Address: "192.0.2.77"}),
+		testTranslation("default", "dedicated", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443, DedicatedLoadBalancer: true}),
+	)
+
+	if err := c.Refresh(c.s); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	lb, err := c.options().KubeClientSet.CoreV1().Services("kube-pat").Get(context.TODO(), "lb", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the load balancer: %v", err)
+	}
+	want := []corev1.ServicePort{
+		{Name: "ssh", Protocol: corev1.ProtocolTCP, Port: 22},
+		{Name: "default-configured-443", Protocol: corev1.ProtocolTCP, Port: 443},
+	}
+	if !reflect.DeepEqual(lb.Spec.Ports, want) {
+		t.Errorf("load balancer ports = %+v, want %+v", lb.Spec.Ports, want)
+	}
+
+	// The dedicated load balancer of a translation waiting for it is created,
+	// its target port isn't a port of another translation.
+	config, err := dedicatedLoadBalancerConfig(*opt.DedicatedLoadBalancer, PortForwardingConfig{PortAddressTranslationName: "default/dedicated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, name, _ := splitName(config.Name)
+	dedicated, err := c.options().KubeClientSet.CoreV1().Services("kube-pat").Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the dedicated load balancer: %v", err)
+	}
+	if len(dedicated.Spec.Ports) != 1 || dedicated.Spec.Ports[0].Port != 443 || dedicated.Spec.Ports[0].TargetPort.IntVal != 40001 {
+		t.Errorf("dedicated load balancer ports = %+v, want 443 to target port 40001", dedicated.Spec.Ports)
+	}
+}
+
+func TestRefreshDrainsRemovedTranslations(t *testing.T) {
+	opt := ControllerOptions{
+		LoadBalancers:         map[string]map[corev1.Protocol]string{DefaultLoadBalancer: {corev1.ProtocolTCP: "kube-pat/lb"}},
+		DedicatedLoadBalancer: &LoadBalancerConfig{Name: "kube-pat/dedicated", Selector: map[string]string{"app": "kube-pat"}},
+		DrainGracePeriod:      time.Hour,
+	}
+	config, err := dedicatedLoadBalancerConfig(*opt.DedicatedLoadBalancer, PortForwardingConfig{PortAddressTranslationName: "default/dedicated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dedicated, err := newLoadBalancerService(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dedicated.Annotations[portaddresstranslation.OwnedPortsAnnotationKey] = "default-dedicated-443"
+	dedicated.Spec.Ports = []corev1.ServicePort{{Name: "default-dedicated-443", Protocol: corev1.ProtocolTCP, Port: 443, TargetPort: intstr.FromInt(40000)}}
+
+	services := []runtime.Object{
+		testService("default", "web", corev1.ProtocolTCP, 8080),
+		testLoadBalancer("kube-pat", "lb", corev1.ServicePort{Name: "ssh", Protocol: corev1.ProtocolTCP, Port: 22}),
+		dedicated,
+	}
+	c := newTestController(opt, newFakeIPTables(), append(services,
+		testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443}),
+		testTranslation("default", "dedicated", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443, DedicatedLoadBalancer: true}),
+	)...)
+	if err := c.Refresh(c.s); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+
+	// The translations are removed, their ports are kept on the load
+	// balancers until the grace period is over.
+	if err := c.Refresh(newTestStore(services...)); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if len(c.draining) != 2 {
+		t.Errorf("draining = %v, want the removed translations", c.draining)
+	}
+	client := c.options().KubeClientSet.CoreV1().Services("kube-pat")
+	lb, err := client.Get(context.TODO(), "lb", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the load balancer: %v", err)
+	}
+	want := []corev1.ServicePort{
+		{Name: "ssh", Protocol: corev1.ProtocolTCP, Port: 22},
+		{Name: "default-web-443", Protocol: corev1.ProtocolTCP, Port: 443},
+	}
+	if !reflect.DeepEqual(lb.Spec.Ports, want) {
+		t.Errorf("load balancer ports = %+v, want %+v", lb.Spec.Ports, want)
+	}
+	if _, err := client.Get(context.TODO(), dedicated.Name, metav1.GetOptions{}); err != nil {
+		t.Errorf("dedicated load balancer of a draining translation: %v", err)
+	}
+}
+
+func TestRefreshEmptyManagedLoadBalancer(t *testing.T) {
+	for _, deleteWhenEmpty := range []bool{false, true} {
+		config := LoadBalancerConfig{
+			Pool: DefaultLoadBalancer, Protocol: corev1.ProtocolTCP, Name: "kube-pat/lb",
+			Selector: map[string]string{"app": "kube-pat"}, DeleteWhenEmpty: deleteWhenEmpty,
+		}
+		opt := ControllerOptions{
+			LoadBalancers:        map[string]map[corev1.Protocol]string{DefaultLoadBalancer: {corev1.ProtocolTCP: "kube-pat/lb"}},
+			ManagedLoadBalancers: map[string]LoadBalancerConfig{"kube-pat/lb": config},
+		}
+		lb := testLoadBalancer("kube-pat", "lb", corev1.ServicePort{Name: "default-web-443", Protocol: corev1.ProtocolTCP, Port: 443})
+		lb.Annotations[portaddresstranslation.OwnedPortsAnnotationKey] = "default-web-443"
+		lb.Spec.Selector = config.Selector
+		c := newTestController(opt, newFakeIPTables(), lb)
+
+		if err := c.Refresh(c.s); err != nil {
+			t.Fatalf("Refresh() error = %v", err)
+		}
+		_, err := c.options().KubeClientSet.CoreV1().Services("kube-pat").Get(context.TODO(), "lb", metav1.GetOptions{})
+		if deleted := errors.IsNotFound(err); deleted != deleteWhenEmpty {
+			t.Errorf("load balancer deleted = %t with deleteWhenEmpty %t", deleted, deleteWhenEmpty)
+		}
+	}
+}
+
+func TestRefreshPortPools(t *testing.T) {
+	ipt := newFakeIPTables()
+	opt := ControllerOptions{
+		LoadBalancers: map[string]map[corev1.Protocol]string{DefaultLoadBalancer: {corev1.ProtocolTCP: "kube-pat/lb"}},
+		PortPools:     map[string]PortRange{DefaultLoadBalancer: {First: 1, Last: 1023}},
+	}
+	c := newTestController(opt, ipt,
+		testService("default", "web", corev1.ProtocolTCP, 8080),
+		testTranslation("default", "https", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443}),
+		testTranslation("default", "alt", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 8443}),
+	)
+	c.leader.Store(false)
+
+	if err := c.Refresh(c.s); err != nil {
+		t.Fatalf("Refresh() error = %v", err)
+	}
+	if got, want := names(c.state.lastDesired()), []string{"default/https"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("configured translations = %q, want %q", got, want)
+	}
+	if _, ok := c.state.failures["default/alt"]; !ok {
+		t.Errorf("failures = %v, want the failure of default/alt", c.state.failures)
+	}
+}
@@ -0,0 +1,93 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// reconcileState is the outcome of the last reconciliation.
+type reconcileState struct {
+	mu       sync.RWMutex
+	time     time.Time
+	err      error
+	desired  []PortForwardingConfig
+	failures map[string]string
+}
+
+func (rs *reconcileState) set(desired []PortForwardingConfig, failures map[string]string, err error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.time = time.Now()
+	rs.err = err
+	rs.desired = desired
+	rs.failures = failures
+}
+
+// lastDesired returns the desired PortForwardingConfigs of the last
+// reconciliation.
+func (rs *reconcileState) lastDesired() []PortForwardingConfig {
+	rs.mu.RLock()
+	defer rs.mu.RUnlock()
+	return rs.desired
+}
+
+// debugState is the document served on /debug/state.
+type debugState struct {
+	LastReconcile      time.Time              `json:"lastReconcile"`
+	LastReconcileError string                 `json:"lastReconcileError,omitempty"`
+	Desired            []PortForwardingConfig `json:"desired"`
+	Appended           []Rule                 `json:"appended"`
+	Installed          map[string][]string    `json:"installed"`
+	Differences        RuleDiff               `json:"differences"`
+	Errors             map[string]string      `json:"errors,omitempty"`
+}
+
+// DebugState serves the desired forwarding configuration, the installed rules
+// and their differences, and the errors of the last reconciliation per
+// translation, as JSON.
+func (c Controller) DebugState(w http.ResponseWriter, r *http.Request) {
+	c.state.mu.RLock()
+	state := debugState{
+		LastReconcile: c.state.time,
+		Desired:       c.state.desired,
+		Errors:        c.state.failures,
+	}
+	if c.state.err != nil {
+		state.LastReconcileError = c.state.err.Error()
+	}
+	c.state.mu.RUnlock()
+
+	var err error
+	state.Appended = c.pf.Rules()
+	if state.Installed, err = c.pf.InstalledRules(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to fetch IPTables rules: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+	if state.Differences, err = c.pf.Diff(); err != nil {
+		http.Error(w, fmt.Sprintf("failed to compare IPTables rules: %s", err.Error()), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(state)
+}
+
+// objectName returns the name of a translation, as in PortForwardingConfig.
+func objectName(obj runtime.Object) string {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+	if accessor.GetNamespace() == "" {
+		return accessor.GetName()
+	}
+	return fmt.Sprintf("%s/%s", accessor.GetNamespace(), accessor.GetName())
+}
@@ -0,0 +1,185 @@
+package forwarder
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/miekg/dns"
+	"k8s.io/klog/v2"
+)
+
+// invalidLabelChars are the characters replaced in the service label of the
+// SRV records.
+var invalidLabelChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+// dnsTTL is the TTL of the records served by the DNSServer, in seconds.
+const dnsTTL = 30
+
+// srvRecord is the target of a SRV record.
+type srvRecord struct {
+	target string
+	port   uint16
+}
+
+// DNSServer answers the DNS queries for the translations of a zone:
+//
+//	<pat>.<namespace>.<zone>                      A/AAAA of the external addresses
+//	_<service>._<proto>.<pat>.<namespace>.<zone>  SRV pointing to the name above
+//
+// The names of ClusterPortAddressTranslations are <pat>.<zone>. The <service>
+// label of the SRV records, as in RFC 2782, is the name of the port of the
+// service of the translation, or the name of the translation for an unnamed
+// port.
+type DNSServer struct {
+	addr string
+	zone string
+
+	mu    sync.RWMutex
+	hosts map[string][]string
+	srvs  map[string]srvRecord
+}
+
+// NewDNSServer creates a new DNSServer listening on addr, over UDP and TCP.
+func NewDNSServer(addr, zone string) *DNSServer {
+	d := new(DNSServer)
+	d.addr = addr
+	d.zone = dns.Fqdn(strings.ToLower(zone))
+	d.hosts = map[string][]string{}
+	d.srvs = map[string]srvRecord{}
+	return d
+}
+
+// SetRecords replaces the records served with the ones of the given
+// translations, published on the given external addresses.
+func (d *DNSServer) SetRecords(pfcs []PortForwardingConfig, addresses map[string][]string) {
+	hosts := map[string][]string{}
+	srvs := map[string]srvRecord{}
+	for _, pfc := range pfcs {
+		if len(addresses[pfc.PortAddressTranslationName]) == 0 {
+			continue
+		}
+		name, err := dnsName(pfc, d.zone)
+		if err != nil {
+			klog.ErrorS(err, "Failed to publish the DNS records", "pat", pfc.PortAddressTranslationName)
+			continue
+		}
+		host := dns.Fqdn(strings.ToLower(name))
+		hosts[host] = addresses[pfc.PortAddressTranslationName]
+		srv := fmt.Sprintf("_%s._%s.%s", srvService(pfc), strings.ToLower(string(pfc.Protocol)), host)
+		srvs[srv] = srvRecord{target: host, port: uint16(pfc.SrcPort)}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hosts = hosts
+	d.srvs = srvs
+}
+
+// srvService returns the service label of the SRV record of a translation.
+func srvService(pfc PortForwardingConfig) string {
+	service := pfc.PortName
+	if service == "" {
+		service = pfc.PortAddressTranslationName[strings.LastIndex(pfc.PortAddressTranslationName, "/")+1:]
+	}
+	service = strings.Trim(invalidLabelChars.ReplaceAllString(strings.ToLower(service), "-"), "-")
+	if len(service) > 62 {
+		// The label is at most 63 characters with its underscore.
+		service = strings.TrimRight(service[:62], "-")
+	}
+	return service
+}
+
+// Run serves the DNS queries until stopCh is closed.
+func (d *DNSServer) Run(stopCh <-chan struct{}) error {
+	errCh := make(chan error, 2)
+	var servers []*dns.Server
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: d.addr, Net: network, Handler: d}
+		servers = append(servers, server)
+		go func(server *dns.Server) {
+			errCh <- server.ListenAndServe()
+		}(server)
+	}
+
+	var err error
+	select {
+	case <-stopCh:
+	case err = <-errCh:
+	}
+	for _, server := range servers {
+		server.Shutdown()
+	}
+	return err
+}
+
+// ServeDNS answers a DNS query.
+func (d *DNSServer) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(r)
+	m.Authoritative = true
+
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	known := false
+	for _, q := range r.Question {
+		name := strings.ToLower(q.Name)
+		if !dns.IsSubDomain(d.zone, name) {
+			m.SetRcode(r, dns.RcodeRefused)
+			w.WriteMsg(m)
+			return
+		}
+
+		if srv, ok := d.srvs[name]; ok {
+			known = true
+			if q.Qtype == dns.TypeSRV || q.Qtype == dns.TypeANY {
+				m.Answer = append(m.Answer, &dns.SRV{
+					Hdr:    dns.RR_Header{Name: q.Name, Rrtype: dns.TypeSRV, Class: dns.ClassINET, Ttl: dnsTTL},
+					Target: srv.target,
+					Port:   srv.port,
+				})
+				m.Extra = append(m.Extra, d.addressRecords(srv.target, dns.TypeANY)...)
+			}
+		}
+		if _, ok := d.hosts[name]; ok {
+			known = true
+			m.Answer = append(m.Answer, d.addressRecords(q.Name, q.Qtype)...)
+		}
+	}
+
+	if !known {
+		m.SetRcode(r, dns.RcodeNameError)
+	}
+	w.WriteMsg(m)
+}
+
+// addressRecords returns the A, AAAA and CNAME records of a host matching a
+// query type.
+func (d *DNSServer) addressRecords(name string, qtype uint16) []dns.RR {
+	var rrs []dns.RR
+	for _, address := range d.hosts[strings.ToLower(name)] {
+		ip := net.ParseIP(address)
+		switch {
+		case ip == nil:
+			// Load balancers of some cloud providers only have a hostname.
+			rrs = append(rrs, &dns.CNAME{
+				Hdr:    dns.RR_Header{Name: name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: dnsTTL},
+				Target: dns.Fqdn(address),
+			})
+		case ip.To4() != nil && (qtype == dns.TypeA || qtype == dns.TypeANY):
+			rrs = append(rrs, &dns.A{
+				Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: dnsTTL},
+				A:   ip.To4(),
+			})
+		case ip.To4() == nil && (qtype == dns.TypeAAAA || qtype == dns.TypeANY):
+			rrs = append(rrs, &dns.AAAA{
+				Hdr:  dns.RR_Header{Name: name, Rrtype: dns.TypeAAAA, Class: dns.ClassINET, Ttl: dnsTTL},
+				AAAA: ip,
+			})
+		}
+	}
+	return rrs
+}
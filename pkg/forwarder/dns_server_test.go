@@ -0,0 +1,173 @@
+package forwarder
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// responseRecorder is a dns.ResponseWriter keeping the message written.
+type responseRecorder struct {
+	dns.ResponseWriter
+	msg *dns.Msg
+}
+
+func (r *responseRecorder) WriteMsg(m *dns.Msg) error {
+	r.msg = m
+	return nil
+}
+
+// records returns the records as "<name> <type> <data>", without their TTL
+// and class.
+func records(rrs []dns.RR) []string {
+	var s []string
+	for _, rr := range rrs {
+		fields := strings.Fields(rr.String())
+		s = append(s, strings.Join(append([]string{fields[0]}, fields[3:]...), " "))
+	}
+	return s
+}
+
+func TestServeDNS(t *testing.T) {
+	d := NewDNSServer("", "Example.com")
+	d.SetRecords([]PortForwardingConfig{
+		{PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolTCP, SrcPort: 443, PortName: "https"},
+		{PortAddressTranslationName: "default/lb", Protocol: corev1.ProtocolUDP, SrcPort: 53},
+		{PortAddressTranslationName: "web.shared", ClusterScoped: true, Protocol: corev1.ProtocolTCP, SrcPort: 8080},
+		{PortAddressTranslationName: "default/pending", Protocol: corev1.ProtocolTCP, SrcPort: 80},
+	}, map[string][]string{
+		"default/web": {"192.0.2.1", "2001:db8::1"},
+		"default/lb":  {"lb.cloud.example.net"},
+		"web.shared":  {"198.51.100.2"},
+	})
+
+	tests := []struct {
+		name      string
+		qname     string
+		qtype     uint16
+		wantRcode int
+		want      []string
+		wantExtra []string
+	}{
+		{
+			name:      "A",
+			qname:     "web.default.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"web.default.example.com. A 192.0.2.1"},
+		},
+		{
+			name:      "AAAA",
+			qname:     "web.default.example.com.",
+			qtype:     dns.TypeAAAA,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"web.default.example.com. AAAA 2001:db8::1"},
+		},
+		{
+			name:      "names are case insensitive",
+			qname:     "WEB.Default.example.COM.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"WEB.Default.example.COM. A 192.0.2.1"},
+		},
+		{
+			name:      "CNAME of a hostname",
+			qname:     "lb.default.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"lb.default.example.com. CNAME lb.cloud.example.net."},
+		},
+		{
+			name:      "cluster translation",
+			qname:     "web.shared.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"web.shared.example.com. A 198.51.100.2"},
+		},
+		{
+			name:      "SRV",
+			qname:     "_https._tcp.web.default.example.com.",
+			qtype:     dns.TypeSRV,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"_https._tcp.web.default.example.com. SRV 0 0 443 web.default.example.com."},
+			wantExtra: []string{"web.default.example.com. A 192.0.2.1", "web.default.example.com. AAAA 2001:db8::1"},
+		},
+		{
+			name:      "SRV name queried for another type",
+			qname:     "_https._tcp.web.default.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeSuccess,
+		},
+		{
+			name:      "unknown protocol",
+			qname:     "_https._udp.web.default.example.com.",
+			qtype:     dns.TypeSRV,
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "SRV named after a translation without port name",
+			qname:     "_lb._udp.lb.default.example.com.",
+			qtype:     dns.TypeSRV,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"_lb._udp.lb.default.example.com. SRV 0 0 53 lb.default.example.com."},
+			wantExtra: []string{"lb.default.example.com. CNAME lb.cloud.example.net."},
+		},
+		{
+			name:      "SRV named after a cluster translation",
+			qname:     "_web-shared._tcp.web.shared.example.com.",
+			qtype:     dns.TypeSRV,
+			wantRcode: dns.RcodeSuccess,
+			want:      []string{"_web-shared._tcp.web.shared.example.com. SRV 0 0 8080 web.shared.example.com."},
+			wantExtra: []string{"web.shared.example.com. A 198.51.100.2"},
+		},
+		{
+			name:      "SRV named after the port number",
+			qname:     "_443._tcp.web.default.example.com.",
+			qtype:     dns.TypeSRV,
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "translation without addresses",
+			qname:     "pending.default.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "unknown name",
+			qname:     "api.default.example.com.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeNameError,
+		},
+		{
+			name:      "outside of the zone",
+			qname:     "web.default.example.org.",
+			qtype:     dns.TypeA,
+			wantRcode: dns.RcodeRefused,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := &responseRecorder{}
+			d.ServeDNS(w, new(dns.Msg).SetQuestion(tt.qname, tt.qtype))
+			if w.msg == nil {
+				t.Fatal("no response written")
+			}
+			if w.msg.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[w.msg.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			if !w.msg.Authoritative {
+				t.Errorf("response isn't authoritative")
+			}
+			if got := records(w.msg.Answer); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("answer = %q, want %q", got, tt.want)
+			}
+			if got := records(w.msg.Extra); !reflect.DeepEqual(got, tt.wantExtra) {
+				t.Errorf("extra = %q, want %q", got, tt.wantExtra)
+			}
+		})
+	}
+}
@@ -0,0 +1,54 @@
+package forwarder
+
+import (
+	"strings"
+	"time"
+
+	"k8s.io/klog/v2"
+)
+
+// runDriftDetection compares the installed rules with the desired rules every
+// interval until stopCh is closed, and reconciles when they differ.
+func (c Controller) runDriftDetection(interval time.Duration, stopCh <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopCh:
+			return
+		case <-ticker.C:
+			if c.detectDrift() {
+				c.Refresh(c.s)
+			}
+		}
+	}
+}
+
+// detectDrift returns true when the installed rules differ from the rules
+// configured by the last reconciliation, logging and counting the drifts.
+func (c Controller) detectDrift() bool {
+	if !c.running.Load().(bool) {
+		return false
+	}
+
+	// A reconciliation in progress is not a drift.
+	c.mu.Lock()
+	diff, err := c.pf.Diff()
+	c.mu.Unlock()
+
+	if err != nil {
+		// The owned chains were likely deleted.
+		klog.ErrorS(err, "Failed to compare the installed rules, repairing")
+		driftsTotal.WithLabelValues("unknown").Inc()
+		return true
+	}
+	for _, rule := range diff.Missing {
+		klog.InfoS("Repairing missing rule", "family", rule.Family, "table", rule.Table, "chain", rule.Chain, "rule", strings.Join(rule.Spec, " "))
+		driftsTotal.WithLabelValues(rule.Family + "/" + rule.Table).Inc()
+	}
+	for key, count := range diff.Unexpected {
+		klog.InfoS("Repairing unexpected rules", "chain", key+"/"+chain, "count", count)
+		driftsTotal.WithLabelValues(key).Add(float64(count))
+	}
+	return len(diff.Missing) > 0 || len(diff.Unexpected) > 0
+}
@@ -0,0 +1,49 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	"k8s.io/klog/v2"
+)
+
+// reportDryRun reports a change skipped in dry run, to the dry run output or
+// to the logs.
+func (c Controller) reportDryRun(action, target, change string) {
+	if c.options().DryRunOutput == nil {
+		klog.InfoS("Dry run, not applying", "action", action, "target", target, "change", change)
+		return
+	}
+	fmt.Fprintf(c.options().DryRunOutput, "# %s %s\n%s\n", action, target, change)
+}
+
+// reportScripts reports the IPTables rules of the last reconciliation, see
+// PortForwarder.Scripts.
+func (c Controller) reportScripts() {
+	scripts := c.pf.Scripts()
+	var families []string
+	for family := range scripts {
+		families = append(families, family)
+	}
+	sort.Strings(families)
+	for _, family := range families {
+		command := "iptables-restore"
+		if family == "IPv6" {
+			command = "ip6tables-restore"
+		}
+		c.reportDryRun(command, "--noflush", scripts[family])
+	}
+}
+
+// Render reconciles once from the synced caches and reports the changes to the
+// dry run output, without running the controller. The controller must be in
+// dry run.
+func (c Controller) Render() error {
+	if !c.options().DryRun {
+		return errors.New("rendering requires a dry run controller")
+	}
+	c.running.Store(true)
+	defer c.running.Store(false)
+	return c.Refresh(c.s)
+}
@@ -0,0 +1,322 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+)
+
+// dnsEndpointResource is the DNSEndpoint resource of external-dns.
+var dnsEndpointResource = schema.GroupVersionResource{Group: "externaldns.k8s.io", Version: "v1alpha1", Resource: "dnsendpoints"}
+
+// dnsEndpointCache holds the DNSEndpoints published for the translations, per
+// namespace/name. It is filled by listing the labeled DNSEndpoints once, then
+// by the writes of the controller. It is guarded by the mu of the Controller.
+type dnsEndpointCache struct {
+	objects map[string]*unstructured.Unstructured
+}
+
+// publishEndpoints publishes the external endpoints of the configured
+// translations on their status, and as DNS records when a DNS zone is
+// configured. The addresses are the external addresses of each translation.
+// The status of the other translations is cleared, and the DNSEndpoints not
+// published anymore are deleted.
+func (c Controller) publishEndpoints(pfcs []PortForwardingConfig, addresses map[string][]string, s *Store) {
+	ports := map[string]int32{}
+	for _, pfc := range pfcs {
+		ports[pfc.PortAddressTranslationName] = pfc.SrcPort
+	}
+	hostnames := c.hostnames(pfcs, addresses, s)
+	synced := c.options().DNSZone != "" && c.syncDNSEndpoints()
+	published := map[string]bool{}
+
+	cpats, cpatsErr := s.ClusterPortAddressTranslations()
+	if cpatsErr != nil {
+		klog.ErrorS(cpatsErr, "Failed to list the cluster translations")
+	}
+	for _, cpat := range cpats {
+		name := cpat.Name
+		if synced && hostnames[name] != "" {
+			published[cpat.Spec.Namespace+"/cluster."+cpat.Name] = true
+			owner := metav1.NewControllerRef(cpat, patv1beta1.SchemeGroupVersion.WithKind("ClusterPortAddressTranslation"))
+			if err := c.updateDNSEndpoint(cpat.Spec.Namespace, "cluster."+cpat.Name, hostnames[name], addresses[name], owner); err != nil {
+				klog.ErrorS(err, "Failed to publish the DNS records", "pat", name)
+			}
+		}
+		status := endpointsStatus(ports[name], addresses[name], hostnames[name])
+		if reflect.DeepEqual(cpat.Status, status) {
+			continue
+		}
+		cpat = cpat.DeepCopy()
+		cpat.Status = status
+		if _, err := c.options().PatClientSet.K8sV1beta1().ClusterPortAddressTranslations().Update(context.TODO(), cpat, metav1.UpdateOptions{}); err != nil {
+			klog.ErrorS(err, "Failed to update the status", "pat", name)
+		}
+	}
+
+	pats, patsErr := s.PortAddressTranslations()
+	if patsErr != nil {
+		klog.ErrorS(patsErr, "Failed to list the translations")
+	}
+	for _, pat := range pats {
+		name := pat.Namespace + "/" + pat.Name
+		if synced && hostnames[name] != "" {
+			published[name] = true
+			owner := metav1.NewControllerRef(pat, patv1beta1.SchemeGroupVersion.WithKind("PortAddressTranslation"))
+			if err := c.updateDNSEndpoint(pat.Namespace, pat.Name, hostnames[name], addresses[name], owner); err != nil {
+				klog.ErrorS(err, "Failed to publish the DNS records", "pat", name)
+			}
+		}
+		status := endpointsStatus(ports[name], addresses[name], hostnames[name])
+		if reflect.DeepEqual(pat.Status, status) {
+			continue
+		}
+		pat = pat.DeepCopy()
+		pat.Status = status
+		if _, err := c.options().PatClientSet.K8sV1beta1().PortAddressTranslations(pat.Namespace).Update(context.TODO(), pat, metav1.UpdateOptions{}); err != nil {
+			klog.ErrorS(err, "Failed to update the status", "pat", name)
+		}
+	}
+
+	// The DNSEndpoints can't be told apart from the published ones when
+	// some translations failed to be listed.
+	if synced && cpatsErr == nil && patsErr == nil {
+		c.deleteDNSEndpoints(published)
+	}
+}
+
+// endpointsStatus returns the status of a translation listening on port.
+func endpointsStatus(port int32, addresses []string, hostname string) patv1beta1.PortAddressTranslationStatus {
+	var status patv1beta1.PortAddressTranslationStatus
+	for _, address := range addresses {
+		status.Endpoints = append(status.Endpoints, net.JoinHostPort(address, fmt.Sprint(port)))
+	}
+	status.Hostname = hostname
+	return status
+}
+
+// hostnames returns the DNS names of the translations with external
+// addresses, per translation name. The translations sharing a DNS name, like
+// the cluster translation web.default and the translation default/web, get
+// none.
+func (c Controller) hostnames(pfcs []PortForwardingConfig, addresses map[string][]string, s *Store) map[string]string {
+	if c.options().DNSZone == "" {
+		return nil
+	}
+	hostnames := map[string]string{}
+	users := map[string][]PortForwardingConfig{}
+	for _, pfc := range pfcs {
+		if len(addresses[pfc.PortAddressTranslationName]) == 0 {
+			continue
+		}
+		hostname, err := dnsName(pfc, c.options().DNSZone)
+		if err != nil {
+			klog.ErrorS(err, "Invalid DNS name", "pat", pfc.PortAddressTranslationName)
+			continue
+		}
+		hostnames[pfc.PortAddressTranslationName] = hostname
+		users[hostname] = append(users[hostname], pfc)
+	}
+
+	for hostname, pfcs := range users {
+		if len(pfcs) < 2 {
+			continue
+		}
+		names := make([]string, 0, len(pfcs))
+		for _, pfc := range pfcs {
+			names = append(names, pfc.PortAddressTranslationName)
+		}
+		sort.Strings(names)
+		message := fmt.Sprintf("DNS name %s is shared by %s", hostname, strings.Join(names, ", "))
+		for _, pfc := range pfcs {
+			klog.ErrorS(nil, "Skipping DNS name", "pat", pfc.PortAddressTranslationName, "hostname", hostname, "translations", names)
+			c.event(translation(pfc, s), corev1.EventTypeWarning, reasonDNSConflict, message)
+			delete(hostnames, pfc.PortAddressTranslationName)
+		}
+	}
+	return hostnames
+}
+
+// externalAddresses returns the IPs and hostnames the traffic of a translation
+// is sent to.
+func (c Controller) externalAddresses(pfc PortForwardingConfig, s *Store) []string {
+	if len(pfc.ExternalIPs) > 0 {
+		return pfc.ExternalIPs
+	}
+
+	var lbName string
+	if pfc.DedicatedLoadBalancer {
+		if c.options().DedicatedLoadBalancer != nil {
+			if config, err := dedicatedLoadBalancerConfig(*c.options().DedicatedLoadBalancer, pfc); err == nil {
+				lbName = config.Name
+			}
+		}
+	} else {
+		lbName = c.options().LoadBalancers[pfc.LoadBalancer][pfc.Protocol]
+		if tcpName := c.options().LoadBalancers[pfc.LoadBalancer][corev1.ProtocolTCP]; c.mixedProtocol.Load().(bool) && tcpName != "" {
+			lbName = tcpName
+		}
+	}
+	namespace, name, err := splitName(lbName)
+	if err != nil {
+		return nil
+	}
+
+	var addresses []string
+	for _, ingress := range s.LoadBalancerIngress(namespace, name) {
+		if ingress.IP != "" {
+			addresses = append(addresses, ingress.IP)
+		} else if ingress.Hostname != "" {
+			addresses = append(addresses, ingress.Hostname)
+		}
+	}
+	return addresses
+}
+
+// syncDNSEndpoints lists the DNSEndpoints published for the translations
+// unless they are cached. It returns false when they can't be listed, like
+// when the DNSEndpoint resource isn't installed.
+func (c Controller) syncDNSEndpoints() bool {
+	if c.dnsEndpoints.objects != nil {
+		return true
+	}
+	selector := labels.SelectorFromSet(labels.Set{portaddresstranslation.DNSEndpointLabelKey: "true"})
+	list, err := c.options().DynamicClient.Resource(dnsEndpointResource).List(context.TODO(), metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		klog.ErrorS(err, "Failed to list the DNSEndpoints")
+		return false
+	}
+	c.dnsEndpoints.objects = map[string]*unstructured.Unstructured{}
+	for i := range list.Items {
+		endpoint := &list.Items[i]
+		c.dnsEndpoints.objects[endpoint.GetNamespace()+"/"+endpoint.GetName()] = endpoint
+	}
+	return true
+}
+
+// updateDNSEndpoint creates or updates the external-dns DNSEndpoint pointing
+// dnsName to the addresses, owned by owner.
+func (c Controller) updateDNSEndpoint(namespace, name, dnsName string, addresses []string, owner *metav1.OwnerReference) error {
+	targets := map[string][]interface{}{}
+	for _, address := range addresses {
+		recordType := "CNAME"
+		if ip := net.ParseIP(address); ip != nil && ip.To4() != nil {
+			recordType = "A"
+		} else if ip != nil {
+			recordType = "AAAA"
+		}
+		targets[recordType] = append(targets[recordType], address)
+	}
+
+	var endpoints []interface{}
+	for _, recordType := range []string{"A", "AAAA", "CNAME"} {
+		if len(targets[recordType]) == 0 {
+			continue
+		}
+		endpoints = append(endpoints, map[string]interface{}{
+			"dnsName":    dnsName,
+			"recordType": recordType,
+			"targets":    targets[recordType],
+		})
+	}
+	owners := []metav1.OwnerReference{*owner}
+
+	key := namespace + "/" + name
+	client := c.options().DynamicClient.Resource(dnsEndpointResource).Namespace(namespace)
+	existing, ok := c.dnsEndpoints.objects[key]
+	if !ok {
+		endpoint := &unstructured.Unstructured{Object: map[string]interface{}{
+			"apiVersion": "externaldns.k8s.io/v1alpha1",
+			"kind":       "DNSEndpoint",
+			"spec": map[string]interface{}{
+				"endpoints": endpoints,
+			},
+		}}
+		endpoint.SetNamespace(namespace)
+		endpoint.SetName(name)
+		endpoint.SetLabels(map[string]string{portaddresstranslation.DNSEndpointLabelKey: "true"})
+		endpoint.SetOwnerReferences(owners)
+		created, err := client.Create(context.TODO(), endpoint, metav1.CreateOptions{})
+		if err == nil {
+			c.dnsEndpoints.objects[key] = created
+			return nil
+		}
+		if !errors.IsAlreadyExists(err) {
+			return err
+		}
+		// The DNSEndpoint was published before being labeled, or by another
+		// leader.
+		if existing, err = client.Get(context.TODO(), name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+
+	current, _, _ := unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+	if reflect.DeepEqual(current, endpoints) && reflect.DeepEqual(existing.GetOwnerReferences(), owners) &&
+		existing.GetLabels()[portaddresstranslation.DNSEndpointLabelKey] == "true" {
+		c.dnsEndpoints.objects[key] = existing
+		return nil
+	}
+	existing = existing.DeepCopy()
+	if err := unstructured.SetNestedSlice(existing.Object, endpoints, "spec", "endpoints"); err != nil {
+		return err
+	}
+	existing.SetOwnerReferences(owners)
+	endpointLabels := existing.GetLabels()
+	if endpointLabels == nil {
+		endpointLabels = map[string]string{}
+	}
+	endpointLabels[portaddresstranslation.DNSEndpointLabelKey] = "true"
+	existing.SetLabels(endpointLabels)
+	updated, err := client.Update(context.TODO(), existing, metav1.UpdateOptions{})
+	if err != nil {
+		// The DNSEndpoint is read again by the next reconciliation.
+		delete(c.dnsEndpoints.objects, key)
+		return err
+	}
+	c.dnsEndpoints.objects[key] = updated
+	return nil
+}
+
+// deleteDNSEndpoints deletes the cached DNSEndpoints which are not published,
+// per namespace/name.
+func (c Controller) deleteDNSEndpoints(published map[string]bool) {
+	for key, endpoint := range c.dnsEndpoints.objects {
+		if published[key] {
+			continue
+		}
+		err := c.options().DynamicClient.Resource(dnsEndpointResource).Namespace(endpoint.GetNamespace()).Delete(context.TODO(), endpoint.GetName(), metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to delete DNSEndpoint", "dnsEndpoint", key)
+			continue
+		}
+		klog.InfoS("Deleted DNSEndpoint", "dnsEndpoint", key)
+		delete(c.dnsEndpoints.objects, key)
+	}
+}
+
+// dnsName returns the DNS name of a translation in a zone.
+func dnsName(pfc PortForwardingConfig, zone string) (string, error) {
+	if pfc.ClusterScoped {
+		return fmt.Sprintf("%s.%s", pfc.PortAddressTranslationName, zone), nil
+	}
+	namespace, name, err := splitName(pfc.PortAddressTranslationName)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s.%s.%s", name, namespace, zone), nil
+}
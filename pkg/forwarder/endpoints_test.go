@@ -0,0 +1,141 @@
+package forwarder
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	dynamicfake "k8s.io/client-go/dynamic/fake"
+	k8stesting "k8s.io/client-go/testing"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+)
+
+func newFakeDynamicClient(objects ...runtime.Object) *dynamicfake.FakeDynamicClient {
+	return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(),
+		map[schema.GroupVersionResource]string{dnsEndpointResource: "DNSEndpointList"}, objects...)
+}
+
+// testDNSEndpoint returns a DNSEndpoint published by the controller.
+func testDNSEndpoint(namespace, name string) *unstructured.Unstructured {
+	endpoint := &unstructured.Unstructured{Object: map[string]interface{}{
+		"apiVersion": "externaldns.k8s.io/v1alpha1",
+		"kind":       "DNSEndpoint",
+	}}
+	endpoint.SetNamespace(namespace)
+	endpoint.SetName(name)
+	endpoint.SetLabels(map[string]string{portaddresstranslation.DNSEndpointLabelKey: "true"})
+	return endpoint
+}
+
+func TestPublishEndpoints(t *testing.T) {
+	web := testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443})
+	gone := testTranslation("default", "gone", patv1beta1.PortAddressTranslationSpec{Service: "deleted", Port: 80})
+	gone.Status = patv1beta1.PortAddressTranslationStatus{Endpoints: []string{"192.0.2.10:80"}, Hostname: "gone.default.example.com"}
+	// An endpoint published by an older version, without the label.
+	unlabeled := testDNSEndpoint("default", "web")
+	unlabeled.SetLabels(nil)
+
+	dynamicClient := newFakeDynamicClient(unlabeled, testDNSEndpoint("default", "gone"))
+	c := newTestController(ControllerOptions{DNSZone: "example.com", DynamicClient: dynamicClient}, newFakeIPTables(), web, gone)
+
+	pfcs := []PortForwardingConfig{{PortAddressTranslationName: "default/web", SrcPort: 443}}
+	c.publishEndpoints(pfcs, map[string][]string{"default/web": {"192.0.2.10"}}, c.s)
+
+	translations := c.options().PatClientSet.K8sV1beta1().PortAddressTranslations("default")
+	for name, want := range map[string]patv1beta1.PortAddressTranslationStatus{
+		"web":  {Endpoints: []string{"192.0.2.10:443"}, Hostname: "web.default.example.com"},
+		"gone": {},
+	} {
+		pat, err := translations.Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			t.Fatalf("failed to get the translation: %v", err)
+		}
+		if !reflect.DeepEqual(pat.Status, want) {
+			t.Errorf("status of %s = %+v, want %+v", name, pat.Status, want)
+		}
+	}
+
+	endpoints := dynamicClient.Resource(dnsEndpointResource).Namespace("default")
+	endpoint, err := endpoints.Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the DNSEndpoint: %v", err)
+	}
+	if owners := endpoint.GetOwnerReferences(); len(owners) != 1 || owners[0].UID != web.UID {
+		t.Errorf("owners = %+v, want the translation", owners)
+	}
+	if endpoint.GetLabels()[portaddresstranslation.DNSEndpointLabelKey] != "true" {
+		t.Errorf("labels = %v, want the DNSEndpoint label", endpoint.GetLabels())
+	}
+	if _, err := endpoints.Get(context.TODO(), "gone", metav1.GetOptions{}); err == nil {
+		t.Errorf("the DNSEndpoint of a translation without address isn't deleted")
+	}
+
+	// The DNSEndpoints are cached, a reconciliation without change doesn't
+	// read nor write them.
+	dynamicClient.ClearActions()
+	c.publishEndpoints(pfcs, map[string][]string{"default/web": {"192.0.2.10"}}, c.s)
+	if actions := dynamicClient.Actions(); len(actions) != 0 {
+		t.Errorf("actions = %v, want none", actions)
+	}
+}
+
+func TestPublishEndpointsDNSFailure(t *testing.T) {
+	web := testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443})
+	dynamicClient := newFakeDynamicClient()
+	dynamicClient.PrependReactor("create", "dnsendpoints", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, fmt.Errorf("admission webhook denied the request")
+	})
+	c := newTestController(ControllerOptions{DNSZone: "example.com", DynamicClient: dynamicClient}, newFakeIPTables(), web)
+
+	pfcs := []PortForwardingConfig{{PortAddressTranslationName: "default/web", SrcPort: 443}}
+	c.publishEndpoints(pfcs, map[string][]string{"default/web": {"192.0.2.10"}}, c.s)
+
+	pat, err := c.options().PatClientSet.K8sV1beta1().PortAddressTranslations("default").Get(context.TODO(), "web", metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("failed to get the translation: %v", err)
+	}
+	if want := []string{"192.0.2.10:443"}; !reflect.DeepEqual(pat.Status.Endpoints, want) {
+		t.Errorf("endpoints = %q, want %q", pat.Status.Endpoints, want)
+	}
+}
+
+func TestPublishEndpointsDNSNameCollision(t *testing.T) {
+	cpat := &patv1beta1.ClusterPortAddressTranslation{
+		ObjectMeta: metav1.ObjectMeta{Name: "web.default", UID: "uid-cluster"},
+		Spec: patv1beta1.ClusterPortAddressTranslationSpec{
+			Namespace:                  "ingress",
+			PortAddressTranslationSpec: patv1beta1.PortAddressTranslationSpec{Service: "controller", Port: 8443},
+		},
+	}
+	web := testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443})
+	api := testTranslation("default", "api", patv1beta1.PortAddressTranslationSpec{Service: "api", Port: 443})
+	dynamicClient := newFakeDynamicClient()
+	c := newTestController(ControllerOptions{DNSZone: "example.com", DynamicClient: dynamicClient}, newFakeIPTables(), cpat, web, api)
+
+	pfcs := []PortForwardingConfig{
+		{PortAddressTranslationName: "web.default", ClusterScoped: true, SrcPort: 8443},
+		{PortAddressTranslationName: "default/web", SrcPort: 443},
+		{PortAddressTranslationName: "default/api", SrcPort: 443},
+	}
+	addresses := map[string][]string{"web.default": {"192.0.2.10"}, "default/web": {"192.0.2.10"}, "default/api": {"192.0.2.10"}}
+	want := map[string]string{"default/api": "api.default.example.com"}
+	if got := c.hostnames(pfcs, addresses, c.s); !reflect.DeepEqual(got, want) {
+		t.Errorf("hostnames() = %v, want %v", got, want)
+	}
+
+	c.publishEndpoints(pfcs, addresses, c.s)
+	list, err := dynamicClient.Resource(dnsEndpointResource).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatalf("failed to list the DNSEndpoints: %v", err)
+	}
+	if len(list.Items) != 1 || list.Items[0].GetName() != "api" {
+		t.Errorf("DNSEndpoints = %+v, want the one of default/api", list.Items)
+	}
+}
@@ -0,0 +1,139 @@
+package forwarder
+
+import (
+	"sync"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	patscheme "github.com/pdeslaur/kube-pat/pkg/client/clientset/versioned/scheme"
+)
+
+// Reasons of the events recorded on the translations.
+const (
+	reasonProgrammed  = "Programmed"
+	reasonConflict    = "PortConflict"
+	reasonSkipped     = "Skipped"
+	reasonFailed      = "ProgrammingFailed"
+	reasonDNSConflict = "DNSNameConflict"
+)
+
+func init() {
+	// The events reference the translations.
+	utilruntime.Must(patscheme.AddToScheme(scheme.Scheme))
+}
+
+// eventRecorder records events on the translations. An event is only recorded
+// when it differs from the last one of the translation, the refreshes don't
+// repeat them.
+type eventRecorder struct {
+	recorder record.EventRecorder
+
+	mu   sync.Mutex
+	last map[types.UID]string
+}
+
+func newEventRecorder(kube kubernetes.Interface) *eventRecorder {
+	broadcaster := record.NewBroadcaster()
+	broadcaster.StartLogging(klog.V(4).Infof)
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kube.CoreV1().Events("")})
+
+	r := new(eventRecorder)
+	r.recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "kube-pat"})
+	r.last = map[types.UID]string{}
+	return r
+}
+
+// event records an event on a translation, obj may be nil.
+func (r *eventRecorder) event(obj runtime.Object, eventType, reason, message string) {
+	if obj == nil {
+		return
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.last[accessor.GetUID()] == reason+message {
+		return
+	}
+	r.last[accessor.GetUID()] = reason + message
+	r.recorder.Event(obj, eventType, reason, message)
+}
+
+// forget drops the last events of the translations which are not in uids.
+func (r *eventRecorder) forget(uids map[types.UID]bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for uid := range r.last {
+		if !uids[uid] {
+			delete(r.last, uid)
+		}
+	}
+}
+
+// forgetDeletedEvents drops the last events of the translations which are not
+// in the store anymore.
+func (c Controller) forgetDeletedEvents(s *Store) {
+	pats, err := s.PortAddressTranslations()
+	if err != nil {
+		return
+	}
+	cpats, err := s.ClusterPortAddressTranslations()
+	if err != nil {
+		return
+	}
+	uids := map[types.UID]bool{}
+	for _, pat := range pats {
+		uids[pat.UID] = true
+	}
+	for _, cpat := range cpats {
+		uids[cpat.UID] = true
+	}
+	c.events.forget(uids)
+}
+
+// event records an event on a translation when the controller is the leader.
+func (c Controller) event(obj runtime.Object, eventType, reason, message string) {
+	if c.leader.Load().(bool) && !c.options().DryRun {
+		c.events.event(obj, eventType, reason, message)
+	}
+}
+
+// skipEvent records the event of a translation skipped with err.
+func (c Controller) skipEvent(obj runtime.Object, err error) {
+	switch skipReason(err) {
+	case skipPortConflict, skipShadowed:
+		c.event(obj, corev1.EventTypeWarning, reasonConflict, err.Error())
+	default:
+		c.event(obj, corev1.EventTypeWarning, reasonSkipped, err.Error())
+	}
+}
+
+// translation returns the cached translation of a PortForwardingConfig, or nil.
+func translation(pfc PortForwardingConfig, s *Store) runtime.Object {
+	if pfc.ClusterScoped {
+		if cpat, err := s.GetClusterPortAddressTranslation(pfc.PortAddressTranslationName); err == nil {
+			return cpat
+		}
+		return nil
+	}
+	namespace, name, err := splitName(pfc.PortAddressTranslationName)
+	if err != nil {
+		return nil
+	}
+	if pat, err := s.GetPortAddressTranslation(namespace, name); err == nil {
+		return pat
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package forwarder
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+)
+
+func TestForgetDeletedEvents(t *testing.T) {
+	web := testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443})
+	deleted := testTranslation("default", "deleted", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 80})
+	c := newTestController(ControllerOptions{}, newFakeIPTables(), web, deleted)
+
+	c.event(web, corev1.EventTypeNormal, reasonProgrammed, "Forwarding TCP port 443 to service default/web")
+	c.event(deleted, corev1.EventTypeNormal, reasonProgrammed, "Forwarding TCP port 80 to service default/web")
+	c.forgetDeletedEvents(newTestStore(web))
+
+	c.events.mu.Lock()
+	defer c.events.mu.Unlock()
+	if _, ok := c.events.last[deleted.UID]; ok {
+		t.Errorf("the last event of a deleted translation is kept")
+	}
+	if _, ok := c.events.last[web.UID]; !ok {
+		t.Errorf("the last event of %s is forgotten", web.Name)
+	}
+	if len(c.events.last) != 1 {
+		t.Errorf("last events = %v, want the one of %s only", c.events.last, web.Name)
+	}
+}
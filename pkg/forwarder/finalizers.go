@@ -0,0 +1,257 @@
+package forwarder
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+)
+
+// finalizable is an object protected by the finalizer.
+type finalizable struct {
+	name string
+	obj  metav1.Object
+	// publishesPort is true when the object has a port on the load balancers,
+	// which must be withdrawn before the finalizer is removed.
+	publishesPort bool
+	get           func() (metav1.Object, error)
+	patch         func(data []byte) error
+}
+
+// finalizables returns the translations and the load balancer services owned
+// by the controller.
+func (c Controller) finalizables(s *Store) ([]finalizable, error) {
+	var objects []finalizable
+
+	cpats, err := s.ClusterPortAddressTranslations()
+	if err != nil {
+		return nil, err
+	}
+	for _, cpat := range cpats {
+		client := c.options().PatClientSet.K8sV1beta1().ClusterPortAddressTranslations()
+		name := cpat.Name
+		objects = append(objects, finalizable{
+			name:          name,
+			obj:           cpat,
+			publishesPort: true,
+			get:           func() (metav1.Object, error) { return client.Get(context.TODO(), name, metav1.GetOptions{}) },
+			patch: func(data []byte) error {
+				_, err := client.Patch(context.TODO(), name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	pats, err := s.PortAddressTranslations()
+	if err != nil {
+		return nil, err
+	}
+	for _, pat := range pats {
+		client := c.options().PatClientSet.K8sV1beta1().PortAddressTranslations(pat.Namespace)
+		name := pat.Name
+		objects = append(objects, finalizable{
+			name:          fmt.Sprintf("%s/%s", pat.Namespace, pat.Name),
+			obj:           pat,
+			publishesPort: true,
+			get:           func() (metav1.Object, error) { return client.Get(context.TODO(), name, metav1.GetOptions{}) },
+			patch: func(data []byte) error {
+				_, err := client.Patch(context.TODO(), name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	var lbNames []string
+	for lbName := range c.options().ManagedLoadBalancers {
+		lbNames = append(lbNames, lbName)
+	}
+	if c.options().DedicatedLoadBalancer != nil {
+		namespace, _, err := splitName(c.options().DedicatedLoadBalancer.Name)
+		if err != nil {
+			return nil, err
+		}
+		services, err := s.Services(namespace, labels.SelectorFromSet(labels.Set{portaddresstranslation.DedicatedLoadBalancerLabelKey: "true"}))
+		if err != nil {
+			return nil, err
+		}
+		for _, service := range services {
+			lbNames = append(lbNames, fmt.Sprintf("%s/%s", service.Namespace, service.Name))
+		}
+	}
+	for _, lbName := range lbNames {
+		namespace, name, err := splitName(lbName)
+		if err != nil {
+			return nil, err
+		}
+		service, err := s.GetService(namespace, name)
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		client := c.options().KubeClientSet.CoreV1().Services(namespace)
+		objects = append(objects, finalizable{
+			name: lbName,
+			obj:  service,
+			get:  func() (metav1.Object, error) { return client.Get(context.TODO(), name, metav1.GetOptions{}) },
+			patch: func(data []byte) error {
+				_, err := client.Patch(context.TODO(), name, types.MergePatchType, data, metav1.PatchOptions{})
+				return err
+			},
+		})
+	}
+
+	return objects, nil
+}
+
+// reportCleanups records on the objects being deleted that this replica
+// stopped using them. It must be called once the forwarding rules are
+// configured without the objects being deleted.
+func (c Controller) reportCleanups(objects []finalizable) {
+	for _, object := range objects {
+		if object.obj.GetDeletionTimestamp() == nil || !hasFinalizer(object.obj) || cleanedUpBy(object.obj)[c.identity] {
+			continue
+		}
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			obj, err := object.get()
+			if err != nil {
+				return err
+			}
+			replicas := cleanedUpBy(obj)
+			if replicas[c.identity] {
+				return nil
+			}
+			replicas[c.identity] = true
+			return object.patch(metadataPatch(obj, map[string]interface{}{
+				"annotations": map[string]string{portaddresstranslation.CleanedUpAnnotationKey: joinSet(replicas)},
+			}))
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to report the cleanup", "object", object.name)
+		}
+	}
+}
+
+// updateFinalizers adds the finalizer to the objects, and removes it from the
+// objects being deleted once every replica stopped using them. withdrawn is
+// true when the ports of the translations being deleted are withdrawn from the
+// load balancers.
+func (c Controller) updateFinalizers(objects []finalizable, s *Store, withdrawn bool) {
+	replicas, err := c.replicas(s)
+	if err != nil {
+		klog.ErrorS(err, "Failed to list the replicas", "service", c.options().ReplicasService)
+		return
+	}
+
+	for _, object := range objects {
+		deleting := object.obj.GetDeletionTimestamp() != nil
+		switch {
+		case !deleting && hasFinalizer(object.obj):
+			continue
+		case deleting && !hasFinalizer(object.obj):
+			continue
+		case deleting && !cleanedUp(object.obj, replicas):
+			continue
+		case deleting && object.publishesPort && !withdrawn:
+			continue
+		}
+
+		err := retry.RetryOnConflict(retry.DefaultRetry, func() error {
+			obj, err := object.get()
+			if err != nil {
+				return err
+			}
+			var finalizers []string
+			for _, finalizer := range obj.GetFinalizers() {
+				if finalizer != portaddresstranslation.Finalizer {
+					finalizers = append(finalizers, finalizer)
+				}
+			}
+			if !deleting {
+				finalizers = append(finalizers, portaddresstranslation.Finalizer)
+			}
+			if (obj.GetDeletionTimestamp() != nil) != deleting || len(finalizers) == len(obj.GetFinalizers()) {
+				// The object changed, it is updated on the next refresh.
+				return nil
+			}
+			return object.patch(metadataPatch(obj, map[string]interface{}{
+				"finalizers": finalizers,
+			}))
+		})
+		if err != nil && !errors.IsNotFound(err) {
+			klog.ErrorS(err, "Failed to update the finalizers", "object", object.name)
+		}
+	}
+}
+
+// replicas returns the identities of the replicas configuring forwarding rules.
+func (c Controller) replicas(s *Store) ([]string, error) {
+	if c.options().ReplicasService == "" {
+		return []string{c.identity}, nil
+	}
+	namespace, name, err := splitName(c.options().ReplicasService)
+	if err != nil {
+		return nil, err
+	}
+	return s.EndpointsPods(namespace, name)
+}
+
+func hasFinalizer(obj metav1.Object) bool {
+	for _, finalizer := range obj.GetFinalizers() {
+		if finalizer == portaddresstranslation.Finalizer {
+			return true
+		}
+	}
+	return false
+}
+
+// cleanedUpBy returns the replicas which stopped using an object.
+func cleanedUpBy(obj metav1.Object) map[string]bool {
+	replicas := map[string]bool{}
+	for _, replica := range strings.Split(obj.GetAnnotations()[portaddresstranslation.CleanedUpAnnotationKey], ",") {
+		if replica != "" {
+			replicas[replica] = true
+		}
+	}
+	return replicas
+}
+
+// cleanedUp returns true when every replica stopped using an object.
+func cleanedUp(obj metav1.Object, replicas []string) bool {
+	cleaned := cleanedUpBy(obj)
+	for _, replica := range replicas {
+		if !cleaned[replica] {
+			return false
+		}
+	}
+	return true
+}
+
+// metadataPatch returns the merge patch updating the metadata of an object.
+// The patch is rejected with a conflict if the object changed since it was
+// read.
+func metadataPatch(obj metav1.Object, metadata map[string]interface{}) []byte {
+	metadata["resourceVersion"] = obj.GetResourceVersion()
+	data, _ := json.Marshal(map[string]interface{}{"metadata": metadata})
+	return data
+}
+
+func joinSet(set map[string]bool) string {
+	var values []string
+	for value := range set {
+		values = append(values, value)
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
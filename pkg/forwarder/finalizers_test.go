@@ -0,0 +1,98 @@
+package forwarder
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+)
+
+// replicasEndpoints returns the endpoints of the replicas service selecting
+// the ready and the not ready pods.
+func replicasEndpoints(ready []string, notReady []string) *corev1.Endpoints {
+	addresses := func(pods []string) []corev1.EndpointAddress {
+		var addresses []corev1.EndpointAddress
+		for _, pod := range pods {
+			addresses = append(addresses, corev1.EndpointAddress{TargetRef: &corev1.ObjectReference{Kind: "Pod", Name: pod}})
+		}
+		return addresses
+	}
+	return &corev1.Endpoints{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-pat", Name: "replicas"},
+		Subsets:    []corev1.EndpointSubset{{Addresses: addresses(ready), NotReadyAddresses: addresses(notReady)}},
+	}
+}
+
+// deletingTranslation returns a translation being deleted, cleaned up by the
+// given replicas.
+func deletingTranslation(cleanedUpBy string) *patv1beta1.PortAddressTranslation {
+	pat := testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443})
+	pat.DeletionTimestamp = &metav1.Time{}
+	pat.Finalizers = []string{portaddresstranslation.Finalizer}
+	pat.Annotations = map[string]string{portaddresstranslation.CleanedUpAnnotationKey: cleanedUpBy}
+	return pat
+}
+
+func TestUpdateFinalizers(t *testing.T) {
+	tests := []struct {
+		name           string
+		pat            *patv1beta1.PortAddressTranslation
+		endpoints      *corev1.Endpoints
+		withdrawn      bool
+		wantFinalizers []string
+	}{
+		{
+			name:           "added to a translation",
+			pat:            testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443}),
+			endpoints:      replicasEndpoints([]string{"kube-pat-0"}, nil),
+			withdrawn:      true,
+			wantFinalizers: []string{portaddresstranslation.Finalizer},
+		},
+		{
+			name:      "removed once every ready replica cleaned up",
+			pat:       deletingTranslation("kube-pat-0"),
+			endpoints: replicasEndpoints([]string{"kube-pat-0"}, []string{"kube-pat-1"}),
+			withdrawn: true,
+		},
+		{
+			name:           "kept until every ready replica cleaned up",
+			pat:            deletingTranslation("kube-pat-0"),
+			endpoints:      replicasEndpoints([]string{"kube-pat-0", "kube-pat-1"}, nil),
+			withdrawn:      true,
+			wantFinalizers: []string{portaddresstranslation.Finalizer},
+		},
+		{
+			name:           "kept while the port is published",
+			pat:            deletingTranslation("kube-pat-0"),
+			endpoints:      replicasEndpoints([]string{"kube-pat-0"}, nil),
+			withdrawn:      false,
+			wantFinalizers: []string{portaddresstranslation.Finalizer},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestController(ControllerOptions{ReplicasService: "kube-pat/replicas"}, newFakeIPTables(), tt.pat, tt.endpoints)
+			c.identity = "kube-pat-0"
+
+			objects, err := c.finalizables(c.s)
+			if err != nil {
+				t.Fatalf("finalizables() error = %v", err)
+			}
+			c.updateFinalizers(objects, c.s, tt.withdrawn)
+
+			pat, err := c.options().PatClientSet.K8sV1beta1().PortAddressTranslations("default").Get(context.TODO(), "web", metav1.GetOptions{})
+			if err != nil {
+				t.Fatalf("failed to get the translation: %v", err)
+			}
+			if !reflect.DeepEqual(pat.Finalizers, tt.wantFinalizers) {
+				t.Errorf("finalizers = %q, want %q", pat.Finalizers, tt.wantFinalizers)
+			}
+		})
+	}
+}
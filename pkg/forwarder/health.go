@@ -0,0 +1,69 @@
+package forwarder
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// maxReconcileFailures is the number of consecutive failed reconciliations
+// after which the controller is not ready anymore.
+const maxReconcileFailures = 3
+
+// health tracks the state of the forwarding rules of the node.
+type health struct {
+	reconciled atomic.Value
+	draining   atomic.Value
+	failures   int32
+}
+
+func newHealth() *health {
+	h := new(health)
+	h.reconciled.Store(false)
+	h.draining.Store(false)
+	return h
+}
+
+// record records the result of a reconciliation.
+func (h *health) record(err error) {
+	if err != nil {
+		atomic.AddInt32(&h.failures, 1)
+		return
+	}
+	atomic.StoreInt32(&h.failures, 0)
+	h.reconciled.Store(true)
+}
+
+// Ready returns an error until the caches are synced and the forwarding rules
+// are configured, or when the reconciliations keep failing, or while the
+// controller is draining.
+func (c Controller) Ready() error {
+	if c.health.draining.Load().(bool) {
+		return errors.New("draining")
+	}
+	if !c.running.Load().(bool) {
+		return errors.New("caches are not synced")
+	}
+	if !c.health.reconciled.Load().(bool) {
+		return errors.New("forwarding rules are not configured")
+	}
+	if failures := atomic.LoadInt32(&c.health.failures); failures >= maxReconcileFailures {
+		return fmt.Errorf("last %d reconciliations failed", failures)
+	}
+	return nil
+}
+
+// Healthz answers the liveness probes.
+func (c Controller) Healthz(w http.ResponseWriter, r *http.Request) {
+	fmt.Fprintln(w, "ok")
+}
+
+// Readyz answers the readiness probes, see Controller.Ready.
+func (c Controller) Readyz(w http.ResponseWriter, r *http.Request) {
+	if err := c.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
@@ -0,0 +1,69 @@
+package forwarder
+
+import (
+	"context"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+)
+
+const (
+	leaseDuration = 15 * time.Second
+	renewDeadline = 10 * time.Second
+	retryPeriod   = 2 * time.Second
+)
+
+// runLeaderElection campaigns for the lease until stopCh is closed. The
+// controller is the leader while it holds the lease, it refreshes on every
+// change of leadership.
+func (c Controller) runLeaderElection(stopCh <-chan struct{}) {
+	namespace, name, err := splitName(c.options().LeaderElectionLease)
+	if err != nil {
+		klog.ErrorS(err, "Invalid leader election lease")
+		return
+	}
+	lock := &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{Namespace: namespace, Name: name},
+		Client:    c.options().KubeClientSet.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{
+			Identity: c.identity,
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopCh
+		cancel()
+	}()
+
+	// RunOrDie returns when the lease is lost, the controller campaigns again
+	// as a follower.
+	for ctx.Err() == nil {
+		leaderelection.RunOrDie(ctx, leaderelection.LeaderElectionConfig{
+			Lock:            lock,
+			LeaseDuration:   leaseDuration,
+			RenewDeadline:   renewDeadline,
+			RetryPeriod:     retryPeriod,
+			ReleaseOnCancel: true,
+			Callbacks: leaderelection.LeaderCallbacks{
+				OnStartedLeading: func(ctx context.Context) {
+					klog.InfoS("Started leading", "lease", c.options().LeaderElectionLease, "identity", c.identity)
+					c.leader.Store(true)
+					c.Refresh(c.s)
+				},
+				OnStoppedLeading: func() {
+					klog.InfoS("Stopped leading", "lease", c.options().LeaderElectionLease)
+					c.leader.Store(false)
+				},
+				OnNewLeader: func(leader string) {
+					if leader != c.identity {
+						klog.InfoS("Following leader", "lease", c.options().LeaderElectionLease, "leader", leader)
+					}
+				},
+			},
+		})
+	}
+}
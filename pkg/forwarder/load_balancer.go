@@ -0,0 +1,159 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+)
+
+// loadBalancerPort returns the service port publishing a PortForwardingConfig.
+func loadBalancerPort(pfc PortForwardingConfig) corev1.ServicePort {
+	return corev1.ServicePort{
+		Name:     fmt.Sprintf("%s-%d", strings.Replace(pfc.PortAddressTranslationName, "/", "-", -1), pfc.SrcPort),
+		Protocol: pfc.Protocol,
+		Port:     pfc.SrcPort,
+	}
+}
+
+// ownedPorts returns the names of the ports of a service managed by the
+// controller, listed in an annotation. The ports of a service updated before
+// the annotation existed are migrated: the ones named after the port of a
+// known translation are adopted, see loadBalancerPort, and migrated is true so
+// that the annotation is written.
+func ownedPorts(service *corev1.Service, known map[string]bool) (owned map[string]bool, migrated bool) {
+	owned = map[string]bool{}
+	if _, ok := service.Annotations[portaddresstranslation.OwnedPortsAnnotationKey]; !ok {
+		for _, port := range service.Spec.Ports {
+			if known[port.Name] {
+				owned[port.Name] = true
+			}
+		}
+		return owned, true
+	}
+	for _, name := range strings.Split(service.Annotations[portaddresstranslation.OwnedPortsAnnotationKey], ",") {
+		if name != "" {
+			owned[name] = true
+		}
+	}
+	return owned, false
+}
+
+// mergePorts returns the ports of a service once the ports managed by the
+// controller are replaced with the desired ports. Ports not managed by the
+// controller are kept. A desired port colliding with one of them is skipped.
+// known are the names of the ports of the known translations, see ownedPorts.
+func mergePorts(service *corev1.Service, desired []corev1.ServicePort, known map[string]bool) (ports []corev1.ServicePort, owned []string, changed bool) {
+	isOwned, changed := ownedPorts(service, known)
+	current := map[string]corev1.ServicePort{}
+	foreign := map[string]bool{}
+	for _, port := range service.Spec.Ports {
+		if isOwned[port.Name] {
+			current[port.Name] = port
+			continue
+		}
+		ports = append(ports, port)
+		foreign[portKey(port)] = true
+	}
+
+	sort.Slice(desired, func(i, j int) bool { return desired[i].Name < desired[j].Name })
+	for _, port := range desired {
+		if foreign[portKey(port)] {
+			klog.InfoS("Port is already used by load balancer", "port", portKey(port), "service", klog.KObj(service))
+			continue
+		}
+		existing, ok := current[port.Name]
+		if ok && existing.Protocol == port.Protocol && existing.Port == port.Port {
+			// Keep the fields allocated by Kubernetes, like the node port.
+			port = existing
+		} else {
+			changed = true
+		}
+		delete(current, port.Name)
+		ports = append(ports, port)
+		owned = append(owned, port.Name)
+	}
+
+	if len(current) > 0 {
+		changed = true
+	}
+	return ports, owned, changed
+}
+
+// loadBalancerPatch returns the merge patch updating the ports of a service to
+// the desired ports, or nil when the service is up to date. The other fields
+// of a service owned by the controller are reconciled with its config. The
+// patch is rejected with a conflict if the service changed since it was read.
+func loadBalancerPatch(service *corev1.Service, desired []corev1.ServicePort, known map[string]bool, config *LoadBalancerConfig) ([]byte, error) {
+	labels := map[string]string{}
+	annotations := map[string]string{}
+	spec := map[string]interface{}{}
+
+	// Kubernetes doesn't allow an empty list of ports in services, see
+	// Controller.applyLoadBalancer.
+	ports, owned, changed := mergePorts(service, desired, known)
+	if changed && len(ports) > 0 {
+		annotations[portaddresstranslation.OwnedPortsAnnotationKey] = strings.Join(owned, ",")
+		spec["ports"] = ports
+	}
+
+	if config != nil {
+		for k, v := range config.Labels {
+			if service.Labels[k] != v {
+				labels[k] = v
+			}
+		}
+		for k, v := range config.Annotations {
+			if service.Annotations[k] != v {
+				annotations[k] = v
+			}
+		}
+		if !reflect.DeepEqual(config.Selector, service.Spec.Selector) {
+			selector := map[string]interface{}{}
+			for k := range service.Spec.Selector {
+				selector[k] = nil
+			}
+			for k, v := range config.Selector {
+				selector[k] = v
+			}
+			spec["selector"] = selector
+		}
+		if service.Spec.Type != corev1.ServiceTypeLoadBalancer {
+			spec["type"] = corev1.ServiceTypeLoadBalancer
+		}
+		if config.LoadBalancerIP != service.Spec.LoadBalancerIP {
+			spec["loadBalancerIP"] = config.LoadBalancerIP
+		}
+		if config.ExternalTrafficPolicy != "" && config.ExternalTrafficPolicy != service.Spec.ExternalTrafficPolicy {
+			spec["externalTrafficPolicy"] = config.ExternalTrafficPolicy
+		}
+	}
+
+	if len(labels) == 0 && len(annotations) == 0 && len(spec) == 0 {
+		return nil, nil
+	}
+
+	metadata := map[string]interface{}{
+		"resourceVersion": service.ResourceVersion,
+	}
+	if len(labels) > 0 {
+		metadata["labels"] = labels
+	}
+	if len(annotations) > 0 {
+		metadata["annotations"] = annotations
+	}
+	return json.Marshal(map[string]interface{}{
+		"metadata": metadata,
+		"spec":     spec,
+	})
+}
+
+func portKey(port corev1.ServicePort) string {
+	return fmt.Sprintf("%s:%d", port.Protocol, port.Port)
+}
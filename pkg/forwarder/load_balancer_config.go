@@ -0,0 +1,223 @@
+package forwarder
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// LoadBalancerConfig describes a load balancer service created and owned by
+// the controller.
+type LoadBalancerConfig struct {
+	// Pool is the load balancer pool the service belongs to.
+	Pool string `json:"pool"`
+
+	// Protocol is the protocol of the traffic handled by the service.
+	Protocol corev1.Protocol `json:"protocol"`
+
+	// Name is the name of the service, as namespace/name.
+	Name string `json:"name"`
+
+	// Labels are added to the service.
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Annotations are added to the service, usually to configure the cloud
+	// provider.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	LoadBalancerIP        string                                  `json:"loadBalancerIP,omitempty"`
+	ExternalTrafficPolicy corev1.ServiceExternalTrafficPolicyType `json:"externalTrafficPolicy,omitempty"`
+
+	// Selector selects the forwarder pods.
+	Selector map[string]string `json:"selector"`
+
+	// Ports are static ports of the service, not managed by the translations.
+	Ports []corev1.ServicePort `json:"ports,omitempty"`
+
+	// DeleteWhenEmpty deletes the service, and releases its address, when the
+	// last port of its translations is withdrawn and it has no static ports.
+	// The service is kept with its last port otherwise.
+	DeleteWhenEmpty bool `json:"deleteWhenEmpty,omitempty"`
+
+	// TargetPorts are the target ports of the load balancers dedicated to a
+	// single translation, defaultTargetPorts when nil. They must not be used
+	// by other translations. Only used by the dedicated template.
+	TargetPorts *PortRange `json:"targetPorts,omitempty"`
+}
+
+// PortRange is a range of ports, bounds included.
+type PortRange struct {
+	First int32 `json:"first"`
+	Last  int32 `json:"last"`
+}
+
+// contains returns true when port is in the range.
+func (r PortRange) contains(port int32) bool {
+	return port >= r.First && port <= r.Last
+}
+
+// defaultTargetPorts are the target ports of the dedicated load balancers when
+// not configured.
+var defaultTargetPorts = PortRange{First: 40000, Last: 49999}
+
+// targetPorts returns the target ports of the dedicated load balancers.
+func (config LoadBalancerConfig) targetPorts() PortRange {
+	if config.TargetPorts == nil {
+		return defaultTargetPorts
+	}
+	return *config.TargetPorts
+}
+
+// LoadBalancerConfigs is the content of the load balancers configuration file.
+type LoadBalancerConfigs struct {
+	LoadBalancers []LoadBalancerConfig `json:"loadBalancers"`
+
+	// Dedicated is the template of the load balancers dedicated to a single
+	// translation. Its name is the namespace/prefix of the services, its pool
+	// and protocol are ignored.
+	Dedicated *LoadBalancerConfig `json:"dedicated,omitempty"`
+}
+
+// LoadLoadBalancerConfigs reads a YAML or JSON load balancers configuration
+// file.
+func LoadLoadBalancerConfigs(path string) (*LoadBalancerConfigs, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var configs LoadBalancerConfigs
+	if err = yaml.NewYAMLOrJSONDecoder(f, 4096).Decode(&configs); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %s", path, err.Error())
+	}
+	if err = configs.validate(path); err != nil {
+		return nil, err
+	}
+	return &configs, nil
+}
+
+// validate checks the load balancers read from the file at path.
+func (configs LoadBalancerConfigs) validate(path string) error {
+	for _, config := range configs.LoadBalancers {
+		if config.Pool == "" || config.Name == "" || len(config.Selector) == 0 {
+			return fmt.Errorf("load balancer %q in %s requires a pool, a name and a selector", config.Name, path)
+		}
+		if config.Protocol != corev1.ProtocolTCP && config.Protocol != corev1.ProtocolUDP {
+			return fmt.Errorf("load balancer %s in %s has unsupported protocol %q", config.Name, path, config.Protocol)
+		}
+		namespace, name, err := splitName(config.Name)
+		if err == nil && (len(validation.IsDNS1123Label(namespace)) > 0 || len(validation.IsDNS1035Label(name)) > 0) {
+			err = fmt.Errorf("%q is not a valid service name", config.Name)
+		}
+		if err != nil {
+			return fmt.Errorf("load balancer %s in %s must be namespace/name: %s", config.Name, path, err.Error())
+		}
+	}
+	if configs.Dedicated != nil {
+		namespace, prefix, err := splitName(configs.Dedicated.Name)
+		if err != nil || len(validation.IsDNS1123Label(namespace)) > 0 || len(configs.Dedicated.Selector) == 0 {
+			return fmt.Errorf("dedicated load balancers in %s require a namespace/prefix name and a selector", path)
+		}
+		if errs := validation.IsDNS1035Label(prefix); len(errs) > 0 || len(prefix) > maxDedicatedPrefixLength {
+			return fmt.Errorf("prefix %q of the dedicated load balancers in %s must be a DNS label of at most %d characters", prefix, path, maxDedicatedPrefixLength)
+		}
+	}
+	if configs.Dedicated != nil && configs.Dedicated.TargetPorts != nil {
+		if ports := configs.Dedicated.TargetPorts; ports.First < 1 || ports.First > ports.Last || ports.Last > 65535 {
+			return fmt.Errorf("invalid target ports %d-%d of the dedicated load balancers in %s", ports.First, ports.Last, path)
+		}
+	}
+	return nil
+}
+
+// newLoadBalancerService returns the service described by a LoadBalancerConfig.
+func newLoadBalancerService(config LoadBalancerConfig) (*corev1.Service, error) {
+	namespace, name, err := splitName(config.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:   namespace,
+			Name:        name,
+			Labels:      copyMap(config.Labels),
+			Annotations: copyMap(config.Annotations),
+		},
+		Spec: corev1.ServiceSpec{
+			Type:                  corev1.ServiceTypeLoadBalancer,
+			Selector:              copyMap(config.Selector),
+			LoadBalancerIP:        config.LoadBalancerIP,
+			ExternalTrafficPolicy: config.ExternalTrafficPolicy,
+			Ports:                 append([]corev1.ServicePort(nil), config.Ports...),
+		},
+	}, nil
+}
+
+// maxDedicatedPrefixLength is the maximum length of the prefix of the
+// dedicated load balancers, followed by a dash and a 16 characters hash in
+// service names limited to 63 characters.
+const maxDedicatedPrefixLength = 63 - 17
+
+// dedicatedLoadBalancerConfig returns the config of the load balancer dedicated
+// to a PortForwardingConfig, from the dedicated load balancers template.
+func dedicatedLoadBalancerConfig(template LoadBalancerConfig, pfc PortForwardingConfig) (LoadBalancerConfig, error) {
+	namespace, prefix, err := splitName(template.Name)
+	if err != nil {
+		return LoadBalancerConfig{}, err
+	}
+
+	// Service names are DNS labels. The name of the translation is hashed, it
+	// may contain dots, and replacing its slash makes it ambiguous. The name of
+	// a PortAddressTranslation has a slash, the name of a
+	// ClusterPortAddressTranslation doesn't, they can't collide.
+	sum := sha256.Sum256([]byte(pfc.PortAddressTranslationName))
+	name := fmt.Sprintf("%s-%x", prefix, sum[:8])
+
+	config := template
+	config.Name = fmt.Sprintf("%s/%s", namespace, name)
+	config.Protocol = pfc.Protocol
+	config.Labels = copyMap(template.Labels)
+	config.Labels[portaddresstranslation.DedicatedLoadBalancerLabelKey] = "true"
+	config.Annotations = copyMap(template.Annotations)
+	config.Annotations[portaddresstranslation.TranslationAnnotationKey] = pfc.PortAddressTranslationName
+	return config, nil
+}
+
+// dedicatedTargetPort returns the target port of the port publishing a
+// PortForwardingConfig on its dedicated load balancer, or 0.
+func dedicatedTargetPort(service *corev1.Service, pfc PortForwardingConfig) int32 {
+	for _, port := range service.Spec.Ports {
+		if port.Protocol == pfc.Protocol && port.Port == pfc.SrcPort {
+			return port.TargetPort.IntVal
+		}
+	}
+	return 0
+}
+
+// allocateTargetPort returns the first port of a range not used for a
+// protocol, and marks it as used.
+func allocateTargetPort(ports PortRange, protocol corev1.Protocol, used map[string]bool) (int32, error) {
+	for port := ports.First; port <= ports.Last; port++ {
+		key := fmt.Sprintf("%s:%d", protocol, port)
+		if !used[key] {
+			used[key] = true
+			return port, nil
+		}
+	}
+	return 0, fmt.Errorf("no %s target port left between %d and %d", protocol, ports.First, ports.Last)
+}
+
+func copyMap(m map[string]string) map[string]string {
+	res := map[string]string{}
+	for k, v := range m {
+		res[k] = v
+	}
+	return res
+}
@@ -0,0 +1,239 @@
+package forwarder
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
+)
+
+func tcpPort(name string, port int32) corev1.ServicePort {
+	return corev1.ServicePort{Name: name, Protocol: corev1.ProtocolTCP, Port: port}
+}
+
+// lbService returns a load balancer service with the given ports. The owned
+// ports annotation is set unless owned is nil.
+func lbService(owned *string, ports ...corev1.ServicePort) *corev1.Service {
+	service := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "kube-pat", Name: "lb", ResourceVersion: "1", Annotations: map[string]string{}},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: ports},
+	}
+	if owned != nil {
+		service.Annotations[portaddresstranslation.OwnedPortsAnnotationKey] = *owned
+	}
+	return service
+}
+
+func owned(names string) *string {
+	return &names
+}
+
+func TestMergePorts(t *testing.T) {
+	withNodePort := tcpPort("default-web-1000", 1000)
+	withNodePort.NodePort = 31000
+
+	tests := []struct {
+		name        string
+		service     *corev1.Service
+		desired     []corev1.ServicePort
+		known       map[string]bool
+		wantPorts   []corev1.ServicePort
+		wantOwned   []string
+		wantChanged bool
+	}{
+		{
+			name:        "foreign ports are kept",
+			service:     lbService(owned(""), tcpPort("http", 80)),
+			desired:     []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+			wantPorts:   []corev1.ServicePort{tcpPort("http", 80), tcpPort("default-web-1000", 1000)},
+			wantOwned:   []string{"default-web-1000"},
+			wantChanged: true,
+		},
+		{
+			name:        "owned ports are replaced",
+			service:     lbService(owned("default-old-2000"), tcpPort("http", 80), tcpPort("default-old-2000", 2000)),
+			desired:     []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+			wantPorts:   []corev1.ServicePort{tcpPort("http", 80), tcpPort("default-web-1000", 1000)},
+			wantOwned:   []string{"default-web-1000"},
+			wantChanged: true,
+		},
+		{
+			name:        "node ports are preserved",
+			service:     lbService(owned("default-web-1000"), withNodePort),
+			desired:     []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+			wantPorts:   []corev1.ServicePort{withNodePort},
+			wantOwned:   []string{"default-web-1000"},
+			wantChanged: false,
+		},
+		{
+			name:        "desired ports are sorted",
+			service:     lbService(owned("")),
+			desired:     []corev1.ServicePort{tcpPort("default-web-1000", 1000), tcpPort("default-api-2000", 2000)},
+			wantPorts:   []corev1.ServicePort{tcpPort("default-api-2000", 2000), tcpPort("default-web-1000", 1000)},
+			wantOwned:   []string{"default-api-2000", "default-web-1000"},
+			wantChanged: true,
+		},
+		{
+			name:        "ports colliding with foreign ports are skipped",
+			service:     lbService(owned(""), tcpPort("http", 80)),
+			desired:     []corev1.ServicePort{tcpPort("default-web-80", 80)},
+			wantPorts:   []corev1.ServicePort{tcpPort("http", 80)},
+			wantChanged: false,
+		},
+		{
+			name:        "ports of services without annotation named after known translations are adopted",
+			service:     lbService(nil, tcpPort("http", 80), tcpPort("default-web-1000", 1000)),
+			desired:     []corev1.ServicePort{tcpPort("default-api-2000", 2000)},
+			known:       map[string]bool{"default-web-1000": true, "default-api-2000": true},
+			wantPorts:   []corev1.ServicePort{tcpPort("http", 80), tcpPort("default-api-2000", 2000)},
+			wantOwned:   []string{"default-api-2000"},
+			wantChanged: true,
+		},
+		{
+			name:        "other ports of services without annotation are kept",
+			service:     lbService(nil, tcpPort("metrics-9090", 9090)),
+			known:       map[string]bool{"default-web-1000": true},
+			wantPorts:   []corev1.ServicePort{tcpPort("metrics-9090", 9090)},
+			wantChanged: true,
+		},
+		{
+			name:        "withdrawing the last port leaves no ports",
+			service:     lbService(owned("default-web-1000"), tcpPort("default-web-1000", 1000)),
+			desired:     nil,
+			wantPorts:   nil,
+			wantChanged: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ports, owned, changed := mergePorts(tt.service, tt.desired, tt.known)
+			if !reflect.DeepEqual(ports, tt.wantPorts) {
+				t.Errorf("ports = %v, want %v", ports, tt.wantPorts)
+			}
+			if !reflect.DeepEqual(owned, tt.wantOwned) {
+				t.Errorf("owned = %v, want %v", owned, tt.wantOwned)
+			}
+			if changed != tt.wantChanged {
+				t.Errorf("changed = %t, want %t", changed, tt.wantChanged)
+			}
+		})
+	}
+}
+
+func TestLoadBalancerPatch(t *testing.T) {
+	unmanaged := lbService(owned("default-web-1000"), tcpPort("default-web-1000", 1000))
+	unmanaged.Spec.Selector = map[string]string{"app": "other"}
+
+	clusterIP := lbService(owned("default-web-1000"), tcpPort("default-web-1000", 1000))
+	clusterIP.Spec.Type = corev1.ServiceTypeClusterIP
+	clusterIP.Spec.Selector = map[string]string{"app": "other", "tier": "edge"}
+
+	tests := []struct {
+		name    string
+		service *corev1.Service
+		desired []corev1.ServicePort
+		known   map[string]bool
+		config  *LoadBalancerConfig
+		want    string
+	}{
+		{
+			name:    "up to date",
+			service: lbService(owned("default-web-1000"), tcpPort("default-web-1000", 1000)),
+			desired: []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+		},
+		{
+			name:    "ports changed",
+			service: lbService(owned("default-web-1000"), tcpPort("http", 80), tcpPort("default-web-1000", 1000)),
+			desired: []corev1.ServicePort{tcpPort("default-api-2000", 2000)},
+			want: `{
+				"metadata": {
+					"resourceVersion": "1",
+					"annotations": {"k8s.deslauriers.io/owned-ports": "default-api-2000"}
+				},
+				"spec": {
+					"ports": [
+						{"name": "http", "protocol": "TCP", "port": 80, "targetPort": 0},
+						{"name": "default-api-2000", "protocol": "TCP", "port": 2000, "targetPort": 0}
+					]
+				}
+			}`,
+		},
+		{
+			name:    "the owned ports of services without annotation are recorded",
+			service: lbService(nil, tcpPort("default-web-1000", 1000)),
+			desired: []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+			known:   map[string]bool{"default-web-1000": true},
+			want: `{
+				"metadata": {
+					"resourceVersion": "1",
+					"annotations": {"k8s.deslauriers.io/owned-ports": "default-web-1000"}
+				},
+				"spec": {
+					"ports": [
+						{"name": "default-web-1000", "protocol": "TCP", "port": 1000, "targetPort": 0}
+					]
+				}
+			}`,
+		},
+		{
+			name:    "empty ports are not patched",
+			service: lbService(owned("default-web-1000"), tcpPort("default-web-1000", 1000)),
+		},
+		{
+			name:    "the fields of unmanaged services are left alone",
+			service: unmanaged,
+			desired: []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+		},
+		{
+			name:    "the fields of managed services are reconciled",
+			service: clusterIP,
+			desired: []corev1.ServicePort{tcpPort("default-web-1000", 1000)},
+			config: &LoadBalancerConfig{
+				Name:     "kube-pat/lb",
+				Labels:   map[string]string{"team": "network"},
+				Selector: map[string]string{"app": "kube-pat"},
+			},
+			want: `{
+				"metadata": {
+					"resourceVersion": "1",
+					"labels": {"team": "network"}
+				},
+				"spec": {
+					"selector": {"app": "kube-pat", "tier": null},
+					"type": "LoadBalancer"
+				}
+			}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			patch, err := loadBalancerPatch(tt.service, tt.desired, tt.known, tt.config)
+			if err != nil {
+				t.Fatalf("loadBalancerPatch() error = %v", err)
+			}
+			if tt.want == "" {
+				if patch != nil {
+					t.Errorf("patch = %s, want none", patch)
+				}
+				return
+			}
+
+			var got, want interface{}
+			if err = json.Unmarshal(patch, &got); err != nil {
+				t.Fatalf("invalid patch %s: %v", patch, err)
+			}
+			if err = json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatalf("invalid expected patch: %v", err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("patch = %s, want %s", patch, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,113 @@
+package forwarder
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/klog/v2"
+)
+
+const metricsNamespace = "kube_pat"
+
+var (
+	reconcilesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconciles_total",
+		Help:      "Number of reconciliations of the forwarding rules, by result.",
+	}, []string{"result"})
+
+	reconcileDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of the reconciliations of the forwarding rules.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	programmedTranslations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "programmed_translations",
+		Help:      "Number of translations with forwarding rules, by protocol.",
+	}, []string{"protocol"})
+
+	skippedTranslations = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Name:      "skipped_translations",
+		Help:      "Number of translations without forwarding rules, by reason.",
+	}, []string{"reason"})
+
+	loadBalancerUpdatesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "load_balancer_updates_total",
+		Help:      "Number of updates of the load balancer services, by result.",
+	}, []string{"load_balancer", "result"})
+
+	driftsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "drifts_total",
+		Help:      "Number of installed rules repaired because they differed from the desired rules, by family and table.",
+	}, []string{"table"})
+
+	configReloadsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Name:      "config_reloads_total",
+		Help:      "Number of reloads of the configuration file, by result.",
+	}, []string{"result"})
+)
+
+func init() {
+	prometheus.MustRegister(reconcilesTotal, reconcileDuration, programmedTranslations, skippedTranslations, loadBalancerUpdatesTotal, driftsTotal, configReloadsTotal)
+}
+
+// result returns the result label of an operation.
+func result(err error) string {
+	if err != nil {
+		return "failure"
+	}
+	return "success"
+}
+
+var (
+	translationPacketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "translation", "packets_total"),
+		"Number of packets forwarded or rejected for a translation.",
+		[]string{"translation"}, nil)
+	translationBytesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "translation", "bytes_total"),
+		"Number of bytes forwarded or rejected for a translation.",
+		[]string{"translation"}, nil)
+	translationDroppedPacketsDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(metricsNamespace, "translation", "dropped_packets_total"),
+		"Number of packets dropped by the limits of a translation.",
+		[]string{"translation"}, nil)
+)
+
+// ruleCollector collects the counters of the forwarding rules when scraped.
+// The counters of a translation keep increasing while it is configured.
+type ruleCollector struct {
+	pf *PortForwarder
+}
+
+func (rc ruleCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- translationPacketsDesc
+	ch <- translationBytesDesc
+	ch <- translationDroppedPacketsDesc
+}
+
+func (rc ruleCollector) Collect(ch chan<- prometheus.Metric) {
+	traffic, err := rc.pf.TrafficCounters()
+	if err != nil {
+		klog.ErrorS(err, "Failed to fetch IPTables counters")
+		return
+	}
+	for name, counters := range traffic {
+		ch <- prometheus.MustNewConstMetric(translationPacketsDesc, prometheus.CounterValue, float64(counters.Packets), name)
+		ch <- prometheus.MustNewConstMetric(translationBytesDesc, prometheus.CounterValue, float64(counters.Bytes), name)
+	}
+
+	drops, err := rc.pf.DropCounters()
+	if err != nil {
+		klog.ErrorS(err, "Failed to fetch IPTables counters")
+		return
+	}
+	for name, packets := range drops {
+		ch <- prometheus.MustNewConstMetric(translationDroppedPacketsDesc, prometheus.CounterValue, float64(packets), name)
+	}
+}
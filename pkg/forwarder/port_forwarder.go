@@ -1,93 +1,849 @@
 package forwarder
 
 import (
-	"errors"
 	"fmt"
-	"os"
+	"hash/fnv"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/coreos/go-iptables/iptables"
 	"k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
 )
 
-const (
-	nic = "eth0"
-)
+// chain is the name of the chains owned by the forwarder in the mangle, nat and
+// filter tables.
+const chain = "KUBE-PAT"
+
+// DefaultInterfaces are the network interfaces of the translated traffic when
+// none are configured.
+var DefaultInterfaces = []string{"eth0"}
+
+// parentChains are the built-in chains jumping to the owned chain, per table.
+var parentChains = map[string]string{
+	"mangle": "PREROUTING",
+	"nat":    "PREROUTING",
+	"filter": "INPUT",
+}
+
+// portEntry is a port used by a forwarding rule. An empty ip stands for any
+// destination IP.
+type portEntry struct {
+	ip       string
+	protocol v1.Protocol
+	port     int32
+}
+
+// Rule is a rule of a chain.
+type Rule struct {
+	Family string   `json:"family"`
+	Table  string   `json:"table"`
+	Chain  string   `json:"chain"`
+	Spec   []string `json:"spec"`
+
+	family iptables.Protocol
+}
+
+// RuleDiff is the difference between the rules appended to the owned chains
+// and the installed rules.
+type RuleDiff struct {
+	// Missing are the appended rules and the base rules which are not
+	// installed.
+	Missing []Rule `json:"missing,omitempty"`
+	// Unexpected is the number of installed rules which were not appended, per
+	// family and table.
+	Unexpected map[string]int `json:"unexpected,omitempty"`
+}
+
+// ruleLog records the rules appended to the owned chains and the ports
+// registered since they were cleared.
+type ruleLog struct {
+	mu    sync.Mutex
+	rules []Rule
+	ports []portEntry
+}
+
+// checkpoint is a marker of the rules appended and the ports registered, see
+// PortForwarder.Rollback.
+type checkpoint struct {
+	rules int
+	ports int
+}
 
-type portMap map[int32]bool
+// iptablesInterface is the part of the IPTables handles used by the
+// PortForwarder.
+type iptablesInterface interface {
+	ClearChain(table, chain string) error
+	DeleteChain(table, chain string) error
+	Append(table, chain string, rulespec ...string) error
+	AppendUnique(table, chain string, rulespec ...string) error
+	Exists(table, chain string, rulespec ...string) (bool, error)
+	Delete(table, chain string, rulespec ...string) error
+	List(table, chain string) ([]string, error)
+	Stats(table, chain string) ([][]string, error)
+}
+
+// flushedCounters are the counters of the rules flushed from the owned chains,
+// per target and name, so that the counters keep increasing across
+// reconfigurations.
+type flushedCounters struct {
+	mu       sync.Mutex
+	counters map[string]map[string]RuleCounters
+}
 
 // PortForwarder configures port address translation to redirect L3 traffic.
 type PortForwarder struct {
-	ipt   *iptables.IPTables
-	ports map[v1.Protocol]portMap
+	ipts    map[iptables.Protocol]iptablesInterface
+	ports   map[portEntry]bool
+	log     *ruleLog
+	flushed *flushedCounters
+
+	// interfaces are the network interfaces receiving the translated traffic
+	// and sending the forwarded traffic, a []string.
+	interfaces *atomic.Value
+
+	// dryRun records the rules without installing them, ipts has no handles.
+	dryRun bool
 }
 
-// NewPortForwarder creates a new PortForwarder.
-func NewPortForwarder() (*PortForwarder, error) {
-	ipt, err := iptables.New()
+// NewPortForwarder creates a new PortForwarder translating the traffic of the
+// given network interfaces. IPv6 forwarding is disabled when ip6tables is not
+// available on the node.
+func NewPortForwarder(interfaces []string) (*PortForwarder, error) {
+	pf := newPortForwarder(map[iptables.Protocol]iptablesInterface{}, interfaces)
+
+	ipt, err := newIPTables(iptables.ProtocolIPv4, pf.baseRules())
 	if err != nil {
 		return nil, err
 	}
+	pf.ipts[iptables.ProtocolIPv4] = ipt
 
-	err = ipt.Append("nat", "POSTROUTING", "-o", nic, "-j", "MASQUERADE")
+	ipt, err = newIPTables(iptables.ProtocolIPv6, pf.baseRules())
 	if err != nil {
-		return nil, errors.New("Failed to configure IPTables with postrouting masquerade")
+		klog.ErrorS(err, "IPv6 forwarding is disabled")
+	} else {
+		pf.ipts[iptables.ProtocolIPv6] = ipt
 	}
 
+	return pf, nil
+}
+
+// NewDryRunPortForwarder creates a PortForwarder recording the rules of both
+// IP families without installing them, see PortForwarder.Scripts.
+func NewDryRunPortForwarder(interfaces []string) *PortForwarder {
+	pf := newPortForwarder(map[iptables.Protocol]iptablesInterface{iptables.ProtocolIPv4: nil, iptables.ProtocolIPv6: nil}, interfaces)
+	pf.dryRun = true
+	return pf
+}
+
+// newPortForwarder creates a PortForwarder installing the rules with the given
+// IPTables handles, per family.
+func newPortForwarder(ipts map[iptables.Protocol]iptablesInterface, interfaces []string) *PortForwarder {
 	pf := new(PortForwarder)
-	pf.ipt = ipt
-	pf.ports = map[v1.Protocol]portMap{
-		v1.ProtocolUDP: portMap{},
-		v1.ProtocolTCP: portMap{},
+	pf.ipts = ipts
+	pf.ports = map[portEntry]bool{}
+	pf.log = new(ruleLog)
+	pf.flushed = &flushedCounters{counters: map[string]map[string]RuleCounters{}}
+	pf.interfaces = new(atomic.Value)
+	pf.interfaces.Store(interfaces)
+	return pf
+}
+
+func newIPTables(family iptables.Protocol, baseRules []Rule) (iptablesInterface, error) {
+	ipt, err := iptables.NewWithProtocol(family)
+	if err != nil {
+		return nil, err
 	}
-	return pf, nil
+
+	for table := range parentChains {
+		// ClearChain creates the chain when it doesn't exist.
+		if err = ipt.ClearChain(table, chain); err != nil {
+			return nil, fmt.Errorf("Failed to create IPTables chain %s/%s: %s", table, chain, err.Error())
+		}
+	}
+	if err = ensureBaseRules(ipt, baseRules); err != nil {
+		return nil, err
+	}
+
+	return ipt, nil
+}
+
+// baseRules are the rules of the built-in chains, masquerading the forwarded
+// traffic and jumping to the owned chains, for each network interface.
+func (pf PortForwarder) baseRules() []Rule {
+	var rules []Rule
+	for _, nic := range pf.interfaces.Load().([]string) {
+		rules = append(rules, Rule{Table: "nat", Chain: "POSTROUTING", Spec: []string{"-o", nic, "-j", "MASQUERADE"}})
+		for table, parent := range parentChains {
+			rules = append(rules, Rule{Table: table, Chain: parent, Spec: []string{"-i", nic, "-j", chain}})
+		}
+	}
+	return rules
+}
+
+// ensureBaseRules appends the base rules missing from the built-in chains.
+func ensureBaseRules(ipt iptablesInterface, baseRules []Rule) error {
+	for _, rule := range baseRules {
+		if err := ipt.AppendUnique(rule.Table, rule.Chain, rule.Spec...); err != nil {
+			return fmt.Errorf("Failed to configure IPTables rule %s/%s %s: %s", rule.Table, rule.Chain, strings.Join(rule.Spec, " "), err.Error())
+		}
+	}
+	return nil
+}
+
+// deleteBaseRules deletes the given base rules from the built-in chains.
+func deleteBaseRules(ipt iptablesInterface, baseRules []Rule) error {
+	for _, rule := range baseRules {
+		exists, err := ipt.Exists(rule.Table, rule.Chain, rule.Spec...)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			continue
+		}
+		if err = ipt.Delete(rule.Table, rule.Chain, rule.Spec...); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
 // NewPortForwarderOrDie creates a new PortForwarder or dies.
-func NewPortForwarderOrDie() *PortForwarder {
-	pf, err := NewPortForwarder()
+func NewPortForwarderOrDie(interfaces []string) *PortForwarder {
+	pf, err := NewPortForwarder(interfaces)
 	if err != nil {
-		panic(nil)
+		panic(err)
 	}
 	return pf
 }
 
-// Clear clears the current forwarding configuration.
+// Clear clears the current forwarding configuration. The counters of the
+// flushed rules are kept, see PortForwarder.TrafficCounters.
 func (pf PortForwarder) Clear() error {
 	pf.resetPorts()
-	return pf.ipt.ClearChain("nat", "PREROUTING")
+	pf.log.mu.Lock()
+	pf.log.rules = nil
+	pf.log.ports = nil
+	pf.log.mu.Unlock()
+	if pf.dryRun {
+		return nil
+	}
+
+	pf.flushed.mu.Lock()
+	defer pf.flushed.mu.Unlock()
+	installed, err := pf.installedCounters()
+	if err != nil {
+		return err
+	}
+	for target, byName := range installed {
+		if pf.flushed.counters[target] == nil {
+			pf.flushed.counters[target] = map[string]RuleCounters{}
+		}
+		for name, c := range byName {
+			pf.flushed.counters[target][name] = pf.flushed.counters[target][name].add(c)
+		}
+	}
+
+	for _, ipt := range pf.ipts {
+		for table := range parentChains {
+			if err := ipt.ClearChain(table, chain); err != nil {
+				return err
+			}
+		}
+		// The base rules may have been flushed by another agent.
+		if err := ensureBaseRules(ipt, pf.baseRules()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Teardown removes the owned chains and the base rules.
+func (pf PortForwarder) Teardown() error {
+	pf.resetPorts()
+	pf.log.mu.Lock()
+	pf.log.rules = nil
+	pf.log.ports = nil
+	pf.log.mu.Unlock()
+	if pf.dryRun {
+		return nil
+	}
+	for _, ipt := range pf.ipts {
+		if err := deleteBaseRules(ipt, pf.baseRules()); err != nil {
+			return err
+		}
+		for table := range parentChains {
+			if err := ipt.ClearChain(table, chain); err != nil {
+				return err
+			}
+			if err := ipt.DeleteChain(table, chain); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// SetInterfaces changes the network interfaces of the translated traffic. The
+// base rules of the previous interfaces are deleted, the ones of the new
+// interfaces are appended when the owned chains are cleared.
+func (pf PortForwarder) SetInterfaces(interfaces []string) error {
+	previous := pf.baseRules()
+	pf.interfaces.Store(interfaces)
+	if pf.dryRun {
+		return nil
+	}
+
+	kept := map[string]bool{}
+	for _, rule := range pf.baseRules() {
+		kept[rule.Table+" "+rule.Chain+" "+strings.Join(rule.Spec, " ")] = true
+	}
+	var removed []Rule
+	for _, rule := range previous {
+		if !kept[rule.Table+" "+rule.Chain+" "+strings.Join(rule.Spec, " ")] {
+			removed = append(removed, rule)
+		}
+	}
+	for _, ipt := range pf.ipts {
+		if err := deleteBaseRules(ipt, removed); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// Forward configures a new forwarding rule.
-func (pf PortForwarder) Forward(p v1.Protocol, srcPort int32, destIP string, destPort int32) error {
-	if err := pf.registerPort(p, srcPort); err != nil {
+// Forward configures a new forwarding rule. Each destination IP is configured
+// for the traffic of its own IP family.
+//
+// The rules matching external IPs must be configured before the rules matching
+// any IP, otherwise they are shadowed.
+func (pf PortForwarder) Forward(pfc PortForwardingConfig) error {
+	// The destinations of a family without IPTables are left out, the
+	// translation fails only when none of its destinations can be reached.
+	var destIPs []string
+	for _, destIP := range pfc.DestIPs {
+		if _, ok := pf.ipts[family(destIP)]; !ok {
+			klog.V(4).InfoS("No IPTables available for destination", "pat", pfc.PortAddressTranslationName, "destination", destIP)
+			continue
+		}
+		destIPs = append(destIPs, destIP)
+	}
+	if len(destIPs) == 0 && len(pfc.DestIPs) > 0 {
+		return fmt.Errorf("No IPTables available for the destinations of %s", pfc.PortAddressTranslationName)
+	}
+
+	if err := pf.registerPort(pfc); err != nil {
+		return err
+	}
+	for _, destIP := range destIPs {
+		match, ok := pf.match(pfc, family(destIP))
+		if !ok {
+			continue
+		}
+		destination := net.JoinHostPort(destIP, fmt.Sprint(pfc.DestPort))
+		err := pf.append(family(destIP), "nat", concat(match, []string{"-j", "DNAT", "--to-destination", destination})...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reject configures a rule refusing the traffic on a port, with a TCP reset or
+// an ICMP port unreachable message depending on the protocol.
+func (pf PortForwarder) Reject(pfc PortForwardingConfig) error {
+	if err := pf.registerPort(pfc); err != nil {
 		return err
 	}
-	destination := fmt.Sprintf("%s:%d", destIP, destPort)
-	return pf.ipt.Append("nat", "PREROUTING", "-p", string(p), "-i", nic, "--dport", fmt.Sprint(srcPort), "-j", "DNAT", "--to-destination", destination)
+	for family := range pf.ipts {
+		match, ok := pf.match(pfc, family)
+		if !ok {
+			continue
+		}
+		rejectWith := "icmp-port-unreachable"
+		if family == iptables.ProtocolIPv6 {
+			rejectWith = "icmp6-port-unreachable"
+		}
+		if pfc.Protocol == v1.ProtocolTCP {
+			rejectWith = "tcp-reset"
+		}
+		err := pf.append(family, "filter", concat(match, []string{"-j", "REJECT", "--reject-with", rejectWith})...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Limit configures rules dropping the traffic above the limits of a
+// PortForwardingConfig. The rules are tagged with its name so that drops can
+// be counted per name.
+func (pf PortForwarder) Limit(pfc PortForwardingConfig) error {
+	name := pfc.PortAddressTranslationName
+	newConn := []string{"-m", "conntrack", "--ctstate", "NEW"}
+
+	for family := range pf.ipts {
+		match, ok := pf.match(pfc, family)
+		if !ok {
+			continue
+		}
+		match = concat(match, []string{"-m", "comment", "--comment", name})
+		mask := "32"
+		if family == iptables.ProtocolIPv6 {
+			mask = "128"
+		}
+
+		var rules [][]string
+		if pfc.Limits.ConnectionsPerSource > 0 {
+			rules = append(rules, concat(newConn, []string{"-m", "connlimit", "--connlimit-above", fmt.Sprint(pfc.Limits.ConnectionsPerSource), "--connlimit-mask", mask}))
+		}
+		if pfc.Limits.NewConnectionsPerSecond > 0 {
+			rules = append(rules, concat(newConn, hashlimit(name, "c", pfc.Limits.NewConnectionsPerSecond)))
+		}
+		if pfc.Limits.PacketsPerSecond > 0 {
+			rules = append(rules, hashlimit(name, "p", pfc.Limits.PacketsPerSecond))
+		}
+
+		for _, rule := range rules {
+			rule = concat(match, rule, []string{"-j", "DROP"})
+			if err := pf.append(family, "mangle", rule...); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Log configures a rule sending the new connections to the NFLOG group, with
+// a prefix derived from the name of the PortForwardingConfig, see nflogPrefix.
+// The rule must be configured after the limits so that dropped connections are
+// not logged.
+func (pf PortForwarder) Log(pfc PortForwardingConfig, group uint16) error {
+	for family := range pf.ipts {
+		match, ok := pf.match(pfc, family)
+		if !ok {
+			continue
+		}
+		err := pf.append(family, "mangle", concat(match, []string{"-m", "conntrack", "--ctstate", "NEW", "-j", "NFLOG", "--nflog-group", fmt.Sprint(group), "--nflog-prefix", nflogPrefix(pfc.PortAddressTranslationName)})...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Account configures a rule counting the packets and bytes of a
+// PortForwardingConfig, tagged with its name. The rule must be configured
+// after the limits and the logging so that dropped packets are not counted,
+// the traffic returns from the chain.
+func (pf PortForwarder) Account(pfc PortForwardingConfig) error {
+	for family := range pf.ipts {
+		match, ok := pf.match(pfc, family)
+		if !ok {
+			continue
+		}
+		err := pf.append(family, "mangle", concat(match, []string{"-m", "comment", "--comment", pfc.PortAddressTranslationName, "-j", "RETURN"})...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// append appends a rule to the owned chain of a table and records it.
+func (pf PortForwarder) append(f iptables.Protocol, table string, spec ...string) error {
+	if !pf.dryRun {
+		if err := pf.ipts[f].Append(table, chain, spec...); err != nil {
+			return err
+		}
+	}
+	pf.log.mu.Lock()
+	defer pf.log.mu.Unlock()
+	pf.log.rules = append(pf.log.rules, Rule{Family: familyName(f), Table: table, Chain: chain, Spec: spec, family: f})
+	return nil
+}
+
+// Checkpoint returns a marker of the rules appended and the ports registered
+// so far, see Rollback.
+func (pf PortForwarder) Checkpoint() checkpoint {
+	pf.log.mu.Lock()
+	defer pf.log.mu.Unlock()
+	return checkpoint{rules: len(pf.log.rules), ports: len(pf.log.ports)}
+}
+
+// Rollback deletes the rules appended and releases the ports registered since
+// a checkpoint, so that a translation failing part-way isn't left half
+// configured and doesn't conflict with the next ones.
+func (pf PortForwarder) Rollback(cp checkpoint) error {
+	pf.log.mu.Lock()
+	defer pf.log.mu.Unlock()
+	for _, entry := range pf.log.ports[cp.ports:] {
+		delete(pf.ports, entry)
+	}
+	pf.log.ports = pf.log.ports[:cp.ports]
+	for i := len(pf.log.rules) - 1; i >= cp.rules; i-- {
+		rule := pf.log.rules[i]
+		if !pf.dryRun {
+			if err := pf.ipts[rule.family].Delete(rule.Table, rule.Chain, rule.Spec...); err != nil {
+				return fmt.Errorf("Failed to delete IPTables rule %s/%s %s: %s", rule.Table, rule.Chain, strings.Join(rule.Spec, " "), err.Error())
+			}
+		}
+		pf.log.rules = pf.log.rules[:i]
+	}
+	return nil
+}
+
+// Rules returns the rules appended to the owned chains since they were
+// cleared.
+func (pf PortForwarder) Rules() []Rule {
+	pf.log.mu.Lock()
+	defer pf.log.mu.Unlock()
+	return append([]Rule(nil), pf.log.rules...)
+}
+
+// InstalledRules returns the rules of the owned chains, per family and table.
+func (pf PortForwarder) InstalledRules() (map[string][]string, error) {
+	installed := map[string][]string{}
+	if pf.dryRun {
+		return installed, nil
+	}
+	for family, ipt := range pf.ipts {
+		for table := range parentChains {
+			rules, err := ipt.List(table, chain)
+			if err != nil {
+				return nil, err
+			}
+			// The first rule is the definition of the chain.
+			installed[familyName(family)+"/"+table] = rules[1:]
+		}
+	}
+	return installed, nil
+}
+
+// Diff compares the rules appended to the owned chains since they were cleared
+// and the base rules with the installed rules. Nothing is installed in dry run,
+// there are no differences.
+func (pf PortForwarder) Diff() (RuleDiff, error) {
+	var diff RuleDiff
+	if pf.dryRun {
+		return diff, nil
+	}
+	for family, ipt := range pf.ipts {
+		for _, rule := range pf.baseRules() {
+			exists, err := ipt.Exists(rule.Table, rule.Chain, rule.Spec...)
+			if err != nil {
+				return RuleDiff{}, err
+			}
+			if !exists {
+				rule.Family, rule.family = familyName(family), family
+				diff.Missing = append(diff.Missing, rule)
+			}
+		}
+	}
+
+	appended := map[string]int{}
+	for _, rule := range pf.Rules() {
+		appended[rule.Family+"/"+rule.Table]++
+		exists, err := pf.ipts[rule.family].Exists(rule.Table, rule.Chain, rule.Spec...)
+		if err != nil {
+			return RuleDiff{}, err
+		}
+		if !exists {
+			diff.Missing = append(diff.Missing, rule)
+		}
+	}
+
+	installed, err := pf.InstalledRules()
+	if err != nil {
+		return RuleDiff{}, err
+	}
+	for key, rules := range installed {
+		if unexpected := len(rules) - appended[key] + countMissing(diff.Missing, key); unexpected > 0 {
+			if diff.Unexpected == nil {
+				diff.Unexpected = map[string]int{}
+			}
+			diff.Unexpected[key] = unexpected
+		}
+	}
+	return diff, nil
+}
+
+// Scripts returns the rules appended to the owned chains since they were
+// cleared as iptables-restore scripts, per family. The scripts flush the owned
+// chains before appending the rules, they are meant to be restored with
+// --noflush. The base rules are not included.
+func (pf PortForwarder) Scripts() map[string]string {
+	rules := pf.Rules()
+	scripts := map[string]string{}
+	for family := range pf.ipts {
+		var script strings.Builder
+		for _, table := range []string{"mangle", "nat", "filter"} {
+			fmt.Fprintf(&script, "*%s\n:%s - [0:0]\n", table, chain)
+			for _, rule := range rules {
+				if rule.family != family || rule.Table != table {
+					continue
+				}
+				fmt.Fprintf(&script, "-A %s %s\n", rule.Chain, quoteSpec(rule.Spec))
+			}
+			script.WriteString("COMMIT\n")
+		}
+		scripts[familyName(family)] = script.String()
+	}
+	return scripts
+}
+
+// quoteSpec joins the arguments of a rule, quoting the ones iptables-restore
+// would split.
+func quoteSpec(spec []string) string {
+	args := make([]string, len(spec))
+	for i, arg := range spec {
+		if arg == "" || strings.ContainsAny(arg, " \t\"'") {
+			arg = strconv.Quote(arg)
+		}
+		args[i] = arg
+	}
+	return strings.Join(args, " ")
 }
 
-// Print prints the IPTables rules of the PREROUTING table.
+// countMissing counts the missing rules of the owned chain of a family and
+// table.
+func countMissing(missing []Rule, key string) int {
+	count := 0
+	for _, rule := range missing {
+		if rule.Chain == chain && rule.Family+"/"+rule.Table == key {
+			count++
+		}
+	}
+	return count
+}
+
+// match returns the match of the incoming traffic of a PortForwardingConfig for
+// an IP family. It returns false when the traffic can't use the IP family.
+func (pf PortForwarder) match(pfc PortForwardingConfig, f iptables.Protocol) ([]string, bool) {
+	match := []string{"-p", string(pfc.Protocol), "--dport", fmt.Sprint(pfc.ListenPort)}
+	if len(pfc.ExternalIPs) == 0 {
+		return match, true
+	}
+
+	var ips []string
+	for _, ip := range pfc.ExternalIPs {
+		if family(ip) == f {
+			ips = append(ips, ip)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, false
+	}
+	return concat(match, []string{"-d", strings.Join(ips, ",")}), true
+}
+
+// RuleCounters are the packets and bytes matched by rules.
+type RuleCounters struct {
+	Packets uint64
+	Bytes   uint64
+}
+
+func (c RuleCounters) add(other RuleCounters) RuleCounters {
+	return RuleCounters{Packets: c.Packets + other.Packets, Bytes: c.Bytes + other.Bytes}
+}
+
+// DropCounters returns the number of packets dropped by the limits, per name.
+func (pf PortForwarder) DropCounters() (map[string]uint64, error) {
+	counters, err := pf.counters("DROP")
+	if err != nil {
+		return nil, err
+	}
+	drops := map[string]uint64{}
+	for name, c := range counters {
+		drops[name] = c.Packets
+	}
+	return drops, nil
+}
+
+// TrafficCounters returns the packets and bytes accepted, per name. See
+// PortForwarder.Account.
+func (pf PortForwarder) TrafficCounters() (map[string]RuleCounters, error) {
+	return pf.counters("RETURN")
+}
+
+// ForgetCounters drops the counters of the flushed rules of the names which
+// are not kept.
+func (pf PortForwarder) ForgetCounters(kept map[string]bool) {
+	pf.flushed.mu.Lock()
+	defer pf.flushed.mu.Unlock()
+	for _, byName := range pf.flushed.counters {
+		for name := range byName {
+			if !kept[name] {
+				delete(byName, name)
+			}
+		}
+	}
+}
+
+// counters sums the counters of the tagged rules of the mangle chain jumping
+// to target and the ones of the flushed rules, per name.
+func (pf PortForwarder) counters(target string) (map[string]RuleCounters, error) {
+	pf.flushed.mu.Lock()
+	defer pf.flushed.mu.Unlock()
+	installed, err := pf.installedCounters()
+	if err != nil {
+		return nil, err
+	}
+	counters := map[string]RuleCounters{}
+	for _, byName := range []map[string]RuleCounters{pf.flushed.counters[target], installed[target]} {
+		for name, c := range byName {
+			counters[name] = counters[name].add(c)
+		}
+	}
+	return counters, nil
+}
+
+// installedCounters sums the counters of the tagged rules of the mangle chain,
+// per target and name.
+func (pf PortForwarder) installedCounters() (map[string]map[string]RuleCounters, error) {
+	counters := map[string]map[string]RuleCounters{}
+	if pf.dryRun {
+		return counters, nil
+	}
+	for _, ipt := range pf.ipts {
+		stats, err := ipt.Stats("mangle", chain)
+		if err != nil {
+			return nil, err
+		}
+		for _, stat := range stats {
+			target := stat[2]
+			if target != "RETURN" && target != "DROP" {
+				continue
+			}
+			name := ruleComment(stat[len(stat)-1])
+			if name == "" {
+				continue
+			}
+			packets, err := strconv.ParseUint(stat[0], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			bytes, err := strconv.ParseUint(stat[1], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			if counters[target] == nil {
+				counters[target] = map[string]RuleCounters{}
+			}
+			counters[target][name] = counters[target][name].add(RuleCounters{Packets: packets, Bytes: bytes})
+		}
+	}
+	return counters, nil
+}
+
+// Print logs the IPTables rules of the owned chains, at verbosity 4.
 func (pf PortForwarder) Print() {
-	fmt.Println("IPTables PREROUTING configuration:")
-	rules, err := pf.ipt.List("nat", "PREROUTING")
+	if !klog.V(4).Enabled() || pf.dryRun {
+		return
+	}
+	for family, ipt := range pf.ipts {
+		for _, table := range []string{"mangle", "nat", "filter"} {
+			rules, err := ipt.List(table, chain)
+			if err != nil {
+				klog.ErrorS(err, "Failed to fetch IPTables rules", "table", table, "family", familyName(family))
+				return
+			}
+			for _, rule := range rules {
+				klog.V(4).InfoS("IPTables rule", "table", table, "family", familyName(family), "rule", rule)
+			}
+		}
+	}
+
+	counters, err := pf.DropCounters()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Failed to fetch IPTables rules: %s\n", err.Error())
+		klog.ErrorS(err, "Failed to fetch IPTables counters")
 		return
 	}
-	for _, rule := range rules {
-		fmt.Println(rule)
+	for name, packets := range counters {
+		klog.V(4).InfoS("Dropped packets", "pat", name, "packets", packets)
 	}
 }
 
 func (pf PortForwarder) resetPorts() {
-	pf.ports[v1.ProtocolUDP] = portMap{}
-	pf.ports[v1.ProtocolTCP] = portMap{}
+	for entry := range pf.ports {
+		delete(pf.ports, entry)
+	}
 }
 
-func (pf PortForwarder) registerPort(protocol v1.Protocol, port int32) error {
-	if pf.ports[protocol][port] {
-		return fmt.Errorf("Port %s:%d is already taken", protocol, port)
+func (pf PortForwarder) registerPort(pfc PortForwardingConfig) error {
+	var entries []portEntry
+	for _, ip := range pfc.ExternalIPs {
+		entries = append(entries, portEntry{ip, pfc.Protocol, pfc.ListenPort})
+	}
+	if len(entries) == 0 {
+		entries = append(entries, portEntry{"", pfc.Protocol, pfc.ListenPort})
+	}
+
+	for _, entry := range entries {
+		if pf.ports[entry] {
+			return skip(skipPortConflict, "Port %s %s:%d is already taken", entry.ip, entry.protocol, entry.port)
+		}
+	}
+	pf.log.mu.Lock()
+	defer pf.log.mu.Unlock()
+	for _, entry := range entries {
+		pf.ports[entry] = true
+		pf.log.ports = append(pf.log.ports, entry)
 	}
-	pf.ports[protocol][port] = true
 	return nil
 }
+
+// family returns the IP family of an IP address.
+func family(ip string) iptables.Protocol {
+	if parsed := net.ParseIP(ip); parsed != nil && parsed.To4() == nil {
+		return iptables.ProtocolIPv6
+	}
+	return iptables.ProtocolIPv4
+}
+
+func familyName(family iptables.Protocol) string {
+	if family == iptables.ProtocolIPv6 {
+		return "IPv6"
+	}
+	return "IPv4"
+}
+
+// hashlimit returns a match for the traffic above rate per second per source IP.
+// The hashlimit name is limited to 15 characters by the kernel, it is derived
+// from a hash of name.
+func hashlimit(name, suffix string, rate int32) []string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	hashlimitName := fmt.Sprintf("kp-%08x-%s", h.Sum32(), suffix)
+	return []string{"-m", "hashlimit", "--hashlimit-name", hashlimitName, "--hashlimit-mode", "srcip", "--hashlimit-above", fmt.Sprintf("%d/sec", rate)}
+}
+
+// nflogPrefix returns the NFLOG prefix of a translation. The kernel truncates
+// the prefixes to 63 characters, the name of the translation is hashed like the
+// hashlimit names and mapped back by the ConnectionLogger.
+func nflogPrefix(name string) string {
+	h := fnv.New32a()
+	h.Write([]byte(name))
+	return fmt.Sprintf("kp-%08x", h.Sum32())
+}
+
+// ruleComment extracts the comment from the options of a listed rule.
+func ruleComment(options string) string {
+	start := strings.Index(options, "/* ")
+	end := strings.Index(options, " */")
+	if start < 0 || end < start {
+		return ""
+	}
+	return options[start+3 : end]
+}
+
+func concat(args ...[]string) []string {
+	var res []string
+	for _, arg := range args {
+		res = append(res, arg...)
+	}
+	return res
+}
@@ -0,0 +1,245 @@
+package forwarder
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/go-iptables/iptables"
+	corev1 "k8s.io/api/core/v1"
+
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+)
+
+// fakeIPTables keeps the rules of its chains in memory, per table/chain. The
+// rules containing one of the unsupported arguments fail to be appended, like
+// the matches of a missing kernel module.
+type fakeIPTables struct {
+	chains      map[string][]string
+	unsupported []string
+}
+
+func newFakeIPTables(unsupported ...string) *fakeIPTables {
+	return &fakeIPTables{chains: map[string][]string{}, unsupported: unsupported}
+}
+
+func (f *fakeIPTables) ClearChain(table, chain string) error {
+	f.chains[table+"/"+chain] = nil
+	return nil
+}
+
+func (f *fakeIPTables) DeleteChain(table, chain string) error {
+	delete(f.chains, table+"/"+chain)
+	return nil
+}
+
+func (f *fakeIPTables) Append(table, chain string, rulespec ...string) error {
+	rule := strings.Join(rulespec, " ")
+	for _, arg := range f.unsupported {
+		if strings.Contains(rule, arg) {
+			return fmt.Errorf("iptables: %s is not supported", arg)
+		}
+	}
+	f.chains[table+"/"+chain] = append(f.chains[table+"/"+chain], rule)
+	return nil
+}
+
+func (f *fakeIPTables) AppendUnique(table, chain string, rulespec ...string) error {
+	if exists, _ := f.Exists(table, chain, rulespec...); exists {
+		return nil
+	}
+	return f.Append(table, chain, rulespec...)
+}
+
+func (f *fakeIPTables) Exists(table, chain string, rulespec ...string) (bool, error) {
+	for _, rule := range f.chains[table+"/"+chain] {
+		if rule == strings.Join(rulespec, " ") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (f *fakeIPTables) Delete(table, chain string, rulespec ...string) error {
+	rules := f.chains[table+"/"+chain]
+	for i, rule := range rules {
+		if rule == strings.Join(rulespec, " ") {
+			f.chains[table+"/"+chain] = append(rules[:i:i], rules[i+1:]...)
+			return nil
+		}
+	}
+	return fmt.Errorf("iptables: bad rule (does a matching rule exist in that chain?)")
+}
+
+func (f *fakeIPTables) List(table, chain string) ([]string, error) {
+	rules := []string{"-N " + chain}
+	for _, rule := range f.chains[table+"/"+chain] {
+		rules = append(rules, fmt.Sprintf("-A %s %s", chain, rule))
+	}
+	return rules, nil
+}
+
+// Stats returns the statistics of the rules with a target, without traffic.
+func (f *fakeIPTables) Stats(table, chain string) ([][]string, error) {
+	var stats [][]string
+	for _, rule := range f.chains[table+"/"+chain] {
+		args := strings.Fields(rule)
+		var target, comment string
+		for i := 0; i < len(args)-1; i++ {
+			switch args[i] {
+			case "-j":
+				target = args[i+1]
+			case "--comment":
+				comment = "/* " + args[i+1] + " */"
+			}
+		}
+		stats = append(stats, []string{"0", "0", target, "all", "--", "*", "*", "0.0.0.0/0", "0.0.0.0/0", comment})
+	}
+	return stats, nil
+}
+
+// owned returns the rules of the owned chain of a table.
+func (f *fakeIPTables) owned(table string) []string {
+	return f.chains[table+"/"+chain]
+}
+
+func newFakePortForwarder(ipts map[iptables.Protocol]iptablesInterface) *PortForwarder {
+	pf := newPortForwarder(ipts, []string{"eth0"})
+	if err := pf.Clear(); err != nil {
+		panic(err)
+	}
+	return pf
+}
+
+func TestPortForwarderRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		pfc       PortForwardingConfig
+		configure func(pf *PortForwarder, pfc PortForwardingConfig) error
+		want      map[string][]string
+		wantIPv6  map[string][]string
+	}{
+		{
+			name: "forward to each family",
+			pfc: PortForwardingConfig{
+				PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolTCP,
+				SrcPort: 443, ListenPort: 443, DestIPs: []string{"10.0.0.1", "fd00::1"}, DestPort: 8443,
+			},
+			configure: func(pf *PortForwarder, pfc PortForwardingConfig) error { return pf.Forward(pfc) },
+			want:      map[string][]string{"nat": {"-p TCP --dport 443 -j DNAT --to-destination 10.0.0.1:8443"}},
+			wantIPv6:  map[string][]string{"nat": {"-p TCP --dport 443 -j DNAT --to-destination [fd00::1]:8443"}},
+		},
+		{
+			name: "forward the traffic of an external IP of its family",
+			pfc: PortForwardingConfig{
+				PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolUDP,
+				SrcPort: 53, ListenPort: 53, ExternalIPs: []string{"192.0.2.1"}, DestIPs: []string{"10.0.0.1", "fd00::1"}, DestPort: 5353,
+			},
+			configure: func(pf *PortForwarder, pfc PortForwardingConfig) error { return pf.Forward(pfc) },
+			want:      map[string][]string{"nat": {"-p UDP --dport 53 -d 192.0.2.1 -j DNAT --to-destination 10.0.0.1:5353"}},
+		},
+		{
+			name: "reject",
+			pfc: PortForwardingConfig{
+				PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolTCP, SrcPort: 80, ListenPort: 80, Reject: true,
+			},
+			configure: func(pf *PortForwarder, pfc PortForwardingConfig) error { return pf.Reject(pfc) },
+			want:      map[string][]string{"filter": {"-p TCP --dport 80 -j REJECT --reject-with tcp-reset"}},
+			wantIPv6:  map[string][]string{"filter": {"-p TCP --dport 80 -j REJECT --reject-with tcp-reset"}},
+		},
+		{
+			name: "limits",
+			pfc: PortForwardingConfig{
+				PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolTCP, SrcPort: 80, ListenPort: 80,
+				ExternalIPs: []string{"fd00::2"},
+				Limits:      &patv1beta1.Limits{ConnectionsPerSource: 10, PacketsPerSecond: 100},
+			},
+			configure: func(pf *PortForwarder, pfc PortForwardingConfig) error { return pf.Limit(pfc) },
+			wantIPv6: map[string][]string{"mangle": {
+				"-p TCP --dport 80 -d fd00::2 -m comment --comment default/web -m conntrack --ctstate NEW -m connlimit --connlimit-above 10 --connlimit-mask 128 -j DROP",
+				"-p TCP --dport 80 -d fd00::2 -m comment --comment default/web " + strings.Join(hashlimit("default/web", "p", 100), " ") + " -j DROP",
+			}},
+		},
+		{
+			name: "accounting",
+			pfc: PortForwardingConfig{
+				PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolUDP, SrcPort: 53, ListenPort: 30053,
+			},
+			configure: func(pf *PortForwarder, pfc PortForwardingConfig) error { return pf.Account(pfc) },
+			want:      map[string][]string{"mangle": {"-p UDP --dport 30053 -m comment --comment default/web -j RETURN"}},
+			wantIPv6:  map[string][]string{"mangle": {"-p UDP --dport 30053 -m comment --comment default/web -j RETURN"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipv4, ipv6 := newFakeIPTables(), newFakeIPTables()
+			pf := newFakePortForwarder(map[iptables.Protocol]iptablesInterface{iptables.ProtocolIPv4: ipv4, iptables.ProtocolIPv6: ipv6})
+			if err := tt.configure(pf, tt.pfc); err != nil {
+				t.Fatalf("error = %v", err)
+			}
+			for _, f := range []struct {
+				ipt  *fakeIPTables
+				want map[string][]string
+			}{{ipv4, tt.want}, {ipv6, tt.wantIPv6}} {
+				for _, table := range []string{"mangle", "nat", "filter"} {
+					if got := f.ipt.owned(table); !reflect.DeepEqual(got, f.want[table]) {
+						t.Errorf("%s rules = %q, want %q", table, got, f.want[table])
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPortForwarderForwardWithoutFamily(t *testing.T) {
+	pf := newFakePortForwarder(map[iptables.Protocol]iptablesInterface{iptables.ProtocolIPv4: newFakeIPTables()})
+	err := pf.Forward(PortForwardingConfig{
+		PortAddressTranslationName: "default/web", Protocol: corev1.ProtocolTCP,
+		SrcPort: 443, ListenPort: 443, DestIPs: []string{"fd00::1"}, DestPort: 8443,
+	})
+	if err == nil {
+		t.Errorf("Forward() of an IPv6 destination without ip6tables succeeded")
+	}
+}
+
+func TestPortForwarderRollback(t *testing.T) {
+	ipt := newFakeIPTables("hashlimit")
+	pf := newFakePortForwarder(map[iptables.Protocol]iptablesInterface{iptables.ProtocolIPv4: ipt})
+	limited := PortForwardingConfig{
+		PortAddressTranslationName: "default/limited", Protocol: corev1.ProtocolTCP,
+		SrcPort: 443, ListenPort: 443, DestIPs: []string{"10.0.0.1"}, DestPort: 8443,
+		Limits: &patv1beta1.Limits{ConnectionsPerSource: 10, NewConnectionsPerSecond: 5},
+	}
+	next := PortForwardingConfig{
+		PortAddressTranslationName: "default/next", Protocol: corev1.ProtocolTCP,
+		SrcPort: 443, ListenPort: 443, DestIPs: []string{"10.0.0.2"}, DestPort: 8443,
+	}
+
+	cp := pf.Checkpoint()
+	if err := pf.Forward(limited); err != nil {
+		t.Fatalf("Forward() error = %v", err)
+	}
+	if err := pf.Limit(limited); err == nil {
+		t.Fatalf("Limit() without hashlimit succeeded")
+	}
+	if err := pf.Rollback(cp); err != nil {
+		t.Fatalf("Rollback() error = %v", err)
+	}
+	if len(ipt.owned("nat")) != 0 || len(ipt.owned("mangle")) != 0 || len(pf.Rules()) != 0 {
+		t.Errorf("rules after the rollback: nat %q, mangle %q, log %v", ipt.owned("nat"), ipt.owned("mangle"), pf.Rules())
+	}
+
+	// The port of the rolled back translation is free again.
+	if err := pf.Forward(next); err != nil {
+		t.Fatalf("Forward() after the rollback error = %v", err)
+	}
+	want := []string{"-p TCP --dport 443 -j DNAT --to-destination 10.0.0.2:8443"}
+	if got := ipt.owned("nat"); !reflect.DeepEqual(got, want) {
+		t.Errorf("nat rules = %q, want %q", got, want)
+	}
+	if err := pf.Forward(limited); skipReason(err) != skipPortConflict {
+		t.Errorf("Forward() on a taken port error = %v, want a port conflict", err)
+	}
+}
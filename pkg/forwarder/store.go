@@ -2,43 +2,114 @@ package forwarder
 
 import (
 	"fmt"
-	"os"
+	"net"
+	"reflect"
+	"sort"
 
+	"github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation"
 	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
 	informers "github.com/pdeslaur/kube-pat/pkg/client/informers/externalversions/portaddresstranslation/v1beta1"
 	listers "github.com/pdeslaur/kube-pat/pkg/client/listers/portaddresstranslation/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
 	corev1informers "k8s.io/client-go/informers/core/v1"
 	corev1listers "k8s.io/client-go/listers/core/v1"
 	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
 )
 
 // PortForwardingConfig is a requested port forwarding operation.
 type PortForwardingConfig struct {
 	Protocol                   corev1.Protocol
 	SrcPort                    int32
-	DestIP                     string
+	DestIPs                    []string
 	DestPort                   int32
+	Reject                     bool
+	Limits                     *patv1beta1.Limits
+	Logging                    bool
+	LoadBalancer               string
+	DedicatedLoadBalancer      bool
+	ExternalIPs                []string
 	PortAddressTranslationName string
+	ClusterScoped              bool
 	ServiceName                string
+
+	// PortName is the name of the port of the service of the translation,
+	// even while it is suspended or falls back to another service.
+	PortName string
+
+	// ListenPort is the port the traffic reaches the forwarder on. It is the
+	// target port of the dedicated load balancer of the translations using
+	// one, SrcPort otherwise.
+	ListenPort int32
+}
+
+// Reasons a translation is skipped.
+const (
+	skipServiceNotFound     = "service_not_found"
+	skipInvalidServiceType  = "invalid_service_type"
+	skipInvalidSpec         = "invalid_spec"
+	skipProtocolMismatch    = "protocol_mismatch"
+	skipShadowed            = "shadowed"
+	skipPortConflict        = "port_conflict"
+	skipUnknownLoadBalancer = "unknown_load_balancer"
+	skipPendingLoadBalancer = "pending_load_balancer"
+	skipNonLocalAddress     = "non_local_address"
+	skipProgrammingFailed   = "programming_failed"
+	skipPortOutOfPool       = "port_out_of_pool"
+)
+
+// skipError is the error of a translation which can't be configured.
+type skipError struct {
+	reason string
+	msg    string
+}
+
+func (e skipError) Error() string {
+	return e.msg
+}
+
+func skip(reason, format string, args ...interface{}) error {
+	return skipError{reason, fmt.Sprintf(format, args...)}
+}
+
+// skipReason returns the reason a translation is skipped from its error.
+func skipReason(err error) string {
+	if e, ok := err.(skipError); ok {
+		return e.reason
+	}
+	return "unknown"
 }
 
 // Store is offering interfaces for intercting with cached entities.
 type Store struct {
-	patLister     listers.PortAddressTranslationLister
-	serviceLister corev1listers.ServiceLister
+	patLister        listers.PortAddressTranslationLister
+	clusterPatLister listers.ClusterPortAddressTranslationLister
+	serviceLister    corev1listers.ServiceLister
+	endpointsLister  corev1listers.EndpointsLister
+
+	// replicasService is the namespace/name of the service selecting the
+	// replicas, see ControllerOptions.ReplicasService.
+	replicasService string
 }
 
 // NewStore creates a new store.
 func NewStore(
 	patInformer informers.PortAddressTranslationInformer,
+	clusterPatInformer informers.ClusterPortAddressTranslationInformer,
 	serviceInformer corev1informers.ServiceInformer,
+	endpointsInformer corev1informers.EndpointsInformer,
+	replicasService string,
 	refreshFunc func(store *Store) error,
 ) *Store {
 	s := new(Store)
+	s.replicasService = replicasService
 	s.patLister = patInformer.Lister()
+	s.clusterPatLister = clusterPatInformer.Lister()
 	s.serviceLister = serviceInformer.Lister()
+	s.endpointsLister = endpointsInformer.Lister()
 
 	patInformer.Informer().AddEventHandler(
 		cache.ResourceEventHandlerFuncs{
@@ -46,7 +117,24 @@ func NewStore(
 				refreshFunc(s)
 			},
 			UpdateFunc: func(oldObj, newObj interface{}) {
-				if oldObj.(*patv1beta1.PortAddressTranslation).GetResourceVersion() != newObj.(*patv1beta1.PortAddressTranslation).GetResourceVersion() {
+				oldPat, newPat := oldObj.(*patv1beta1.PortAddressTranslation), newObj.(*patv1beta1.PortAddressTranslation)
+				if translationChanged(oldPat, newPat, oldPat.Spec, newPat.Spec) {
+					refreshFunc(s)
+				}
+			},
+			DeleteFunc: func(interface{}) {
+				refreshFunc(s)
+			},
+		})
+
+	clusterPatInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(interface{}) {
+				refreshFunc(s)
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldCpat, newCpat := oldObj.(*patv1beta1.ClusterPortAddressTranslation), newObj.(*patv1beta1.ClusterPortAddressTranslation)
+				if translationChanged(oldCpat, newCpat, oldCpat.Spec, newCpat.Spec) {
 					refreshFunc(s)
 				}
 			},
@@ -70,40 +158,374 @@ func NewStore(
 			},
 		})
 
+	// Endpoints change all the time, only the endpoints of the services used
+	// by the translations and of the replicas service matter. The pods
+	// selected by the replicas service are the replicas, the other endpoints
+	// only matter when they start or stop having ready addresses.
+	endpointsInformer.Informer().AddEventHandler(
+		cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				if s.referenced(obj) {
+					refreshFunc(s)
+				}
+			},
+			UpdateFunc: func(oldObj, newObj interface{}) {
+				oldEndpoints, newEndpoints := oldObj.(*corev1.Endpoints), newObj.(*corev1.Endpoints)
+				if !s.referenced(newObj) {
+					return
+				}
+				if hasReadyAddresses(oldEndpoints) != hasReadyAddresses(newEndpoints) || s.isReplicasService(newObj) && !reflect.DeepEqual(oldEndpoints.Subsets, newEndpoints.Subsets) {
+					refreshFunc(s)
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if s.referenced(obj) {
+					refreshFunc(s)
+				}
+			},
+		})
+
 	return s
 }
 
+// referenced returns whether the endpoints of a service, or their tombstone,
+// belong to a service used by a translation or to the replicas service.
+func (s Store) referenced(obj interface{}) bool {
+	if s.isReplicasService(obj) {
+		return true
+	}
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return false
+	}
+	namespace, name, err := cache.SplitMetaNamespaceKey(key)
+	if err != nil {
+		return false
+	}
+	uses := func(spec patv1beta1.PortAddressTranslationSpec) bool {
+		return spec.Service == name || spec.FallbackService == name || spec.MaintenanceService == name
+	}
+
+	pats, err := s.patLister.PortAddressTranslations(namespace).List(labels.Everything())
+	if err != nil {
+		return true
+	}
+	for _, pat := range pats {
+		if uses(pat.Spec) {
+			return true
+		}
+	}
+	cpats, err := s.clusterPatLister.List(labels.Everything())
+	if err != nil {
+		return true
+	}
+	for _, cpat := range cpats {
+		if cpat.Spec.Namespace == namespace && uses(cpat.Spec.PortAddressTranslationSpec) {
+			return true
+		}
+	}
+	return false
+}
+
+// isReplicasService returns whether endpoints, or their tombstone, belong to
+// the replicas service.
+func (s Store) isReplicasService(obj interface{}) bool {
+	key, err := cache.DeletionHandlingMetaNamespaceKeyFunc(obj)
+	return err == nil && s.replicasService != "" && key == s.replicasService
+}
+
+// translationChanged returns whether an update of a translation changes its
+// configuration. The updates of its status and of its finalizers, made by the
+// controller, are ignored.
+func translationChanged(oldObj, newObj metav1.Object, oldSpec, newSpec interface{}) bool {
+	if (oldObj.GetDeletionTimestamp() == nil) != (newObj.GetDeletionTimestamp() == nil) {
+		return true
+	}
+	if oldObj.GetAnnotations()[portaddresstranslation.CleanedUpAnnotationKey] != newObj.GetAnnotations()[portaddresstranslation.CleanedUpAnnotationKey] {
+		return true
+	}
+	return !reflect.DeepEqual(oldSpec, newSpec)
+}
+
 func (s Store) createFromPat(pat *patv1beta1.PortAddressTranslation) (PortForwardingConfig, error) {
-	service, err := s.serviceLister.Services(pat.Namespace).Get(pat.Spec.Service)
+	return s.createFromSpec(pat.Namespace, fmt.Sprintf("%s/%s", pat.Namespace, pat.Name), pat.Spec)
+}
+
+func (s Store) createFromClusterPat(cpat *patv1beta1.ClusterPortAddressTranslation) (PortForwardingConfig, error) {
+	pfc, err := s.createFromSpec(cpat.Spec.Namespace, cpat.Name, cpat.Spec.PortAddressTranslationSpec)
+	pfc.ClusterScoped = true
+	return pfc, err
+}
+
+// createFromSpec creates the PortForwardingConfig of a translation named name,
+// mapping services from namespace.
+func (s Store) createFromSpec(namespace, name string, spec patv1beta1.PortAddressTranslationSpec) (PortForwardingConfig, error) {
+	pfc := PortForwardingConfig{
+		SrcPort:                    spec.Port,
+		ListenPort:                 spec.Port,
+		Limits:                     spec.Limits,
+		Logging:                    spec.Logging,
+		LoadBalancer:               spec.LoadBalancer,
+		DedicatedLoadBalancer:      spec.DedicatedLoadBalancer,
+		PortAddressTranslationName: name,
+	}
+	if pfc.DedicatedLoadBalancer {
+		pfc.LoadBalancer = ""
+	} else if pfc.LoadBalancer == "" {
+		pfc.LoadBalancer = DefaultLoadBalancer
+	}
+	if service, err := s.serviceLister.Services(namespace).Get(spec.Service); err == nil && len(service.Spec.Ports) > 0 {
+		pfc.PortName = service.Spec.Ports[0].Name
+	}
+
+	if spec.Address != "" {
+		if spec.DedicatedLoadBalancer {
+			return PortForwardingConfig{}, skip(skipInvalidSpec, "%s can't be bound to an address and use a dedicated load balancer", name)
+		}
+		if net.ParseIP(spec.Address) == nil {
+			return PortForwardingConfig{}, skip(skipInvalidSpec, "%s is bound to invalid address %q", name, spec.Address)
+		}
+		pfc.ExternalIPs = []string{spec.Address}
+	}
+
+	if spec.Protocol != "" && spec.Protocol != corev1.ProtocolTCP && spec.Protocol != corev1.ProtocolUDP {
+		return PortForwardingConfig{}, skip(skipInvalidSpec, "%s has unsupported protocol %q", name, spec.Protocol)
+	}
+
+	// A suspended translation doesn't use its service. Its protocol is the one
+	// of its spec, or the one of its service while it exists.
+	if spec.Suspend && spec.MaintenanceService == "" {
+		pfc.Protocol = spec.Protocol
+		if pfc.Protocol == "" {
+			service, err := s.serviceLister.Services(namespace).Get(spec.Service)
+			if err == nil && len(service.Spec.Ports) == 0 {
+				err = fmt.Errorf("service has no ports")
+			}
+			if err != nil {
+				return PortForwardingConfig{}, skip(skipServiceNotFound, "suspended translation %s without maintenance service requires a protocol, or service %s/%s for its protocol: %s", name, namespace, spec.Service, err.Error())
+			}
+			pfc.Protocol = service.Spec.Ports[0].Protocol
+		}
+		pfc.Reject = true
+		return pfc, nil
+	}
+	if spec.Suspend {
+		service, err := s.getService(namespace, spec.MaintenanceService, name)
+		if err != nil {
+			return PortForwardingConfig{}, err
+		}
+		pfc.Protocol = service.Spec.Ports[0].Protocol
+		if spec.Protocol != "" && spec.Protocol != pfc.Protocol {
+			return PortForwardingConfig{}, skip(skipProtocolMismatch, "service %s/%s must use protocol %s to be compatible with %s", service.Namespace, service.Name, spec.Protocol, name)
+		}
+		return forwardTo(pfc, service), nil
+	}
+
+	service, err := s.getService(namespace, spec.Service, name)
 	if err != nil {
-		return PortForwardingConfig{}, fmt.Errorf("failed to fetch service %s/%s: %s", pat.Namespace, pat.Spec.Service, err.Error())
+		return PortForwardingConfig{}, err
+	}
+	pfc.Protocol = service.Spec.Ports[0].Protocol
+	if spec.Protocol != "" && spec.Protocol != pfc.Protocol {
+		return PortForwardingConfig{}, skip(skipProtocolMismatch, "service %s/%s must use protocol %s to be compatible with %s", service.Namespace, service.Name, spec.Protocol, name)
+	}
+	if spec.FallbackService != "" && !s.hasReadyEndpoints(service) {
+		service, err = s.getService(namespace, spec.FallbackService, name)
+		if err != nil {
+			return PortForwardingConfig{}, err
+		}
+	}
+	if service.Spec.Ports[0].Protocol != pfc.Protocol {
+		return PortForwardingConfig{}, skip(skipProtocolMismatch, "service %s/%s must use protocol %s to be compatible with %s", service.Namespace, service.Name, pfc.Protocol, name)
+	}
+
+	return forwardTo(pfc, service), nil
+}
+
+// forwardTo returns a PortForwardingConfig forwarding the traffic to a
+// service.
+func forwardTo(pfc PortForwardingConfig, service *corev1.Service) PortForwardingConfig {
+	pfc.DestIPs = clusterIPs(service)
+	pfc.DestPort = service.Spec.Ports[0].Port
+	pfc.ServiceName = fmt.Sprintf("%s/%s", service.Namespace, service.Name)
+	return pfc
+}
+
+func (s Store) getService(namespace, name, owner string) (*corev1.Service, error) {
+	service, err := s.serviceLister.Services(namespace).Get(name)
+	if err != nil {
+		return nil, skip(skipServiceNotFound, "failed to fetch service %s/%s: %s", namespace, name, err.Error())
 	}
 	if service.Spec.Type != corev1.ServiceTypeClusterIP {
-		return PortForwardingConfig{}, fmt.Errorf("service %s/%s must be of type ClusterIP to be compatible with %s/%s", pat.Namespace, pat.Spec.Service, pat.Namespace, pat.Name)
+		return nil, skip(skipInvalidServiceType, "service %s/%s must be of type ClusterIP to be compatible with %s", namespace, name, owner)
+	}
+	return service, nil
+}
+
+// LoadBalancerIngress returns the ingress points of a load balancer service.
+// A service being deleted has no ingress points.
+func (s Store) LoadBalancerIngress(namespace, name string) []corev1.LoadBalancerIngress {
+	service, err := s.serviceLister.Services(namespace).Get(name)
+	if err != nil || service.DeletionTimestamp != nil {
+		return nil
+	}
+	return service.Status.LoadBalancer.Ingress
+}
+
+// GetPortAddressTranslation returns a cached PortAddressTranslation.
+func (s Store) GetPortAddressTranslation(namespace, name string) (*patv1beta1.PortAddressTranslation, error) {
+	return s.patLister.PortAddressTranslations(namespace).Get(name)
+}
+
+// GetClusterPortAddressTranslation returns a cached ClusterPortAddressTranslation.
+func (s Store) GetClusterPortAddressTranslation(name string) (*patv1beta1.ClusterPortAddressTranslation, error) {
+	return s.clusterPatLister.Get(name)
+}
+
+// PortAddressTranslations lists the cached PortAddressTranslations.
+func (s Store) PortAddressTranslations() ([]*patv1beta1.PortAddressTranslation, error) {
+	return s.patLister.PortAddressTranslations("").List(labels.Everything())
+}
+
+// ClusterPortAddressTranslations lists the cached ClusterPortAddressTranslations.
+func (s Store) ClusterPortAddressTranslations() ([]*patv1beta1.ClusterPortAddressTranslation, error) {
+	return s.clusterPatLister.List(labels.Everything())
+}
+
+// GetService returns a cached service.
+func (s Store) GetService(namespace, name string) (*corev1.Service, error) {
+	return s.serviceLister.Services(namespace).Get(name)
+}
+
+// EndpointsPods returns the names of the ready pods behind a service.
+func (s Store) EndpointsPods(namespace, name string) ([]string, error) {
+	endpoints, err := s.endpointsLister.Endpoints(namespace).Get(name)
+	if err != nil {
+		return nil, err
 	}
+	var pods []string
+	for _, subset := range endpoints.Subsets {
+		for _, address := range subset.Addresses {
+			if address.TargetRef != nil && address.TargetRef.Kind == "Pod" {
+				pods = append(pods, address.TargetRef.Name)
+			}
+		}
+	}
+	return pods, nil
+}
 
-	return PortForwardingConfig{
-		Protocol:                   service.Spec.Ports[0].Protocol,
-		SrcPort:                    pat.Spec.Port,
-		DestIP:                     service.Spec.ClusterIP,
-		DestPort:                   service.Spec.Ports[0].Port,
-		PortAddressTranslationName: fmt.Sprintf("%s/%s", pat.Namespace, pat.Name),
-		ServiceName:                fmt.Sprintf("%s/%s", service.Namespace, service.Name),
-	}, nil
+// Services lists the services of a namespace matching a selector.
+func (s Store) Services(namespace string, selector labels.Selector) ([]*corev1.Service, error) {
+	return s.serviceLister.Services(namespace).List(selector)
 }
 
-// Iterate walks through all PortForwardingConfig
+// clusterIPs returns the cluster IPs of a service, one per IP family.
+func clusterIPs(service *corev1.Service) []string {
+	if len(service.Spec.ClusterIPs) > 0 {
+		return service.Spec.ClusterIPs
+	}
+	return []string{service.Spec.ClusterIP}
+}
+
+func (s Store) hasReadyEndpoints(service *corev1.Service) bool {
+	endpoints, err := s.endpointsLister.Endpoints(service.Namespace).Get(service.Name)
+	if err != nil {
+		return false
+	}
+	return hasReadyAddresses(endpoints)
+}
+
+func hasReadyAddresses(endpoints *corev1.Endpoints) bool {
+	for _, subset := range endpoints.Subsets {
+		if len(subset.Addresses) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// addressPort is a port bound to an external address, empty for any address.
+type addressPort struct {
+	address  string
+	protocol corev1.Protocol
+	port     int32
+}
+
+// addressPorts returns the ports bound by a PortForwardingConfig.
+func addressPorts(pfc PortForwardingConfig) []addressPort {
+	if len(pfc.ExternalIPs) == 0 {
+		return []addressPort{{"", pfc.Protocol, pfc.SrcPort}}
+	}
+	var ports []addressPort
+	for _, ip := range pfc.ExternalIPs {
+		ports = append(ports, addressPort{ip, pfc.Protocol, pfc.SrcPort})
+	}
+	return ports
+}
+
+// Iterate walks through all PortForwardingConfig.
+//
+// The ClusterPortAddressTranslations are walked first. A PortAddressTranslation
+// using the same protocol and port as a ClusterPortAddressTranslation is
+// shadowed and skipped, unless they are bound to different addresses. A
+// ClusterPortAddressTranslation bound to any address shadows the port on every
+// address.
+//
+// The translations being deleted are skipped.
 func (s Store) Iterate() <-chan PortForwardingConfig {
+	return s.iterate(func(runtime.Object, error) {})
+}
+
+// iterate walks through all PortForwardingConfig like Iterate, and calls
+// skipped with each translation skipped and its error.
+func (s Store) iterate(skipped func(obj runtime.Object, err error)) <-chan PortForwardingConfig {
 	chnl := make(chan PortForwardingConfig)
 	go func() {
+		clusterPorts := map[addressPort]string{}
+
+		cpats, err := s.clusterPatLister.List(labels.Everything())
+		if err != nil {
+			panic(err)
+		}
+		// The oldest translation keeps a port used by several ones.
+		sort.Slice(cpats, func(i, j int) bool { return olderThan(cpats[i], cpats[j]) })
+		for _, cpat := range cpats {
+			if cpat.DeletionTimestamp != nil {
+				continue
+			}
+			pfc, err := s.createFromClusterPat(cpat)
+			if err != nil {
+				klog.ErrorS(err, "Skipping translation", "pat", cpat.Name, "reason", skipReason(err))
+				skipped(cpat, err)
+				continue
+			}
+			for _, port := range addressPorts(pfc) {
+				if _, ok := clusterPorts[port]; !ok {
+					clusterPorts[port] = pfc.PortAddressTranslationName
+				}
+			}
+			chnl <- pfc
+		}
+
 		pats, err := s.patLister.PortAddressTranslations("").List(labels.Everything())
 		if err != nil {
 			panic(err)
 		}
+		sort.Slice(pats, func(i, j int) bool { return olderThan(pats[i], pats[j]) })
 		for _, pat := range pats {
+			if pat.DeletionTimestamp != nil {
+				continue
+			}
 			pfc, err := s.createFromPat(pat)
 			if err != nil {
-				fmt.Fprintln(os.Stderr, err.Error())
+				klog.ErrorS(err, "Skipping translation", "pat", klog.KObj(pat), "reason", skipReason(err))
+				skipped(pat, err)
+				continue
+			}
+			if owner := shadowedBy(clusterPorts, pfc); owner != "" {
+				err = skip(skipShadowed, "port %s:%d of %s is reserved by cluster translation %s", pfc.Protocol, pfc.SrcPort, pfc.PortAddressTranslationName, owner)
+				klog.ErrorS(err, "Skipping translation", "pat", klog.KObj(pat), "reason", skipReason(err))
+				skipped(pat, err)
 				continue
 			}
 			chnl <- pfc
@@ -113,6 +535,32 @@ func (s Store) Iterate() <-chan PortForwardingConfig {
 	return chnl
 }
 
+// olderThan orders the translations by creation, then by name.
+func olderThan(a, b metav1.Object) bool {
+	created, other := a.GetCreationTimestamp().Time, b.GetCreationTimestamp().Time
+	if !created.Equal(other) {
+		return created.Before(other)
+	}
+	if a.GetNamespace() != b.GetNamespace() {
+		return a.GetNamespace() < b.GetNamespace()
+	}
+	return a.GetName() < b.GetName()
+}
+
+// shadowedBy returns the name of the translation owning a port used by a
+// PortForwardingConfig, or an empty string.
+func shadowedBy(owners map[addressPort]string, pfc PortForwardingConfig) string {
+	if owner, ok := owners[addressPort{"", pfc.Protocol, pfc.SrcPort}]; ok {
+		return owner
+	}
+	for _, port := range addressPorts(pfc) {
+		if owner, ok := owners[port]; ok {
+			return owner
+		}
+	}
+	return ""
+}
+
 func withoutArgs(f func() error) func(interface{}) {
 	return func(interface{}) {
 		f()
@@ -0,0 +1,171 @@
+package forwarder
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/tools/cache"
+
+	patv1beta1 "github.com/pdeslaur/kube-pat/pkg/apis/portaddresstranslation/v1beta1"
+)
+
+func TestCreateFromSpec(t *testing.T) {
+	s := newTestStore(
+		testService("default", "web", corev1.ProtocolTCP, 8080),
+		testService("default", "sorry", corev1.ProtocolTCP, 8080),
+	)
+
+	tests := []struct {
+		name       string
+		spec       patv1beta1.PortAddressTranslationSpec
+		wantReason string
+		want       PortForwardingConfig
+	}{
+		{
+			name: "protocol of the service",
+			spec: patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443},
+			want: PortForwardingConfig{Protocol: corev1.ProtocolTCP, DestPort: 8080, ServiceName: "default/web"},
+		},
+		{
+			name:       "protocol mismatch with the service",
+			spec:       patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443, Protocol: corev1.ProtocolUDP},
+			wantReason: skipProtocolMismatch,
+		},
+		{
+			name:       "unsupported protocol",
+			spec:       patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443, Protocol: corev1.ProtocolSCTP},
+			wantReason: skipInvalidSpec,
+		},
+		{
+			name: "suspended with the protocol of its spec",
+			spec: patv1beta1.PortAddressTranslationSpec{Service: "deleted", Port: 53, Suspend: true, Protocol: corev1.ProtocolUDP},
+			want: PortForwardingConfig{Protocol: corev1.ProtocolUDP, Reject: true},
+		},
+		{
+			name: "suspended with the protocol of its service",
+			spec: patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443, Suspend: true},
+			want: PortForwardingConfig{Protocol: corev1.ProtocolTCP, Reject: true},
+		},
+		{
+			name:       "suspended without protocol nor service",
+			spec:       patv1beta1.PortAddressTranslationSpec{Service: "deleted", Port: 443, Suspend: true},
+			wantReason: skipServiceNotFound,
+		},
+		{
+			name: "suspended with a maintenance service",
+			spec: patv1beta1.PortAddressTranslationSpec{Service: "deleted", Port: 443, Suspend: true, MaintenanceService: "sorry", Protocol: corev1.ProtocolTCP},
+			want: PortForwardingConfig{Protocol: corev1.ProtocolTCP, DestPort: 8080, ServiceName: "default/sorry"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pfc, err := s.createFromSpec("default", "default/pat", tt.spec)
+			if tt.wantReason != "" {
+				if skipReason(err) != tt.wantReason {
+					t.Errorf("createFromSpec() error = %v, want a %s skip", err, tt.wantReason)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("createFromSpec() error = %v", err)
+			}
+			if pfc.Protocol != tt.want.Protocol || pfc.Reject != tt.want.Reject || pfc.DestPort != tt.want.DestPort || pfc.ServiceName != tt.want.ServiceName {
+				t.Errorf("createFromSpec() = %+v, want %+v", pfc, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreReferenced(t *testing.T) {
+	cpat := &patv1beta1.ClusterPortAddressTranslation{
+		ObjectMeta: metav1.ObjectMeta{Name: "shared"},
+		Spec: patv1beta1.ClusterPortAddressTranslationSpec{
+			Namespace:                  "ingress",
+			PortAddressTranslationSpec: patv1beta1.PortAddressTranslationSpec{Service: "controller", Port: 443},
+		},
+	}
+	s := newTestStore(
+		testTranslation("default", "web", patv1beta1.PortAddressTranslationSpec{Service: "web", FallbackService: "sorry", Port: 443}),
+		cpat,
+	)
+	s.replicasService = "kube-pat/replicas"
+
+	endpoints := func(namespace, name string) *corev1.Endpoints {
+		return &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}}
+	}
+	tests := []struct {
+		name string
+		obj  interface{}
+		want bool
+	}{
+		{name: "service", obj: endpoints("default", "web"), want: true},
+		{name: "fallback service", obj: endpoints("default", "sorry"), want: true},
+		{name: "service of a cluster translation", obj: endpoints("ingress", "controller"), want: true},
+		{name: "replicas service", obj: endpoints("kube-pat", "replicas"), want: true},
+		{name: "tombstone", obj: cache.DeletedFinalStateUnknown{Key: "default/web", Obj: endpoints("default", "web")}, want: true},
+		{name: "service of another namespace", obj: endpoints("other", "web"), want: false},
+		{name: "unused service", obj: endpoints("default", "api"), want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := s.referenced(tt.obj); got != tt.want {
+				t.Errorf("referenced() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIterateClusterPrecedence(t *testing.T) {
+	clusterTranslation := func(name string, port int32, address string) *patv1beta1.ClusterPortAddressTranslation {
+		return &patv1beta1.ClusterPortAddressTranslation{
+			ObjectMeta: metav1.ObjectMeta{Name: name},
+			Spec: patv1beta1.ClusterPortAddressTranslationSpec{
+				Namespace:                  "ingress",
+				PortAddressTranslationSpec: patv1beta1.PortAddressTranslationSpec{Service: "controller", Port: port, Address: address},
+			},
+		}
+	}
+	deleted := clusterTranslation("deleted", 8080, "")
+	deleted.DeletionTimestamp = &metav1.Time{}
+
+	s := newTestStore(
+		testService("ingress", "controller", corev1.ProtocolTCP, 8443),
+		testService("default", "web", corev1.ProtocolTCP, 8080),
+		clusterTranslation("https", 443, ""),
+		clusterTranslation("bound", 8443, "192.0.2.1"),
+		deleted,
+		testTranslation("default", "https", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443}),
+		testTranslation("default", "https-bound", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 443, Address: "192.0.2.2"}),
+		testTranslation("default", "other-address", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 8443, Address: "192.0.2.2"}),
+		testTranslation("default", "same-address", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 8443, Address: "192.0.2.1"}),
+		testTranslation("default", "released", patv1beta1.PortAddressTranslationSpec{Service: "web", Port: 8080}),
+	)
+
+	skipped := map[string]string{}
+	var got []string
+	for pfc := range s.iterate(func(obj runtime.Object, err error) {
+		skipped[objectName(obj)] = skipReason(err)
+	}) {
+		got = append(got, pfc.PortAddressTranslationName)
+	}
+
+	// The cluster translations come first, a translation bound to any address
+	// reserves the port on every address.
+	want := []string{"bound", "https", "default/other-address", "default/released"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("translations = %q, want %q", got, want)
+	}
+	wantSkipped := map[string]string{
+		"default/https":        skipShadowed,
+		"default/https-bound":  skipShadowed,
+		"default/same-address": skipShadowed,
+	}
+	if !reflect.DeepEqual(skipped, wantSkipped) {
+		t.Errorf("skipped = %v, want %v", skipped, wantSkipped)
+	}
+}